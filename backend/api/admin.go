@@ -0,0 +1,242 @@
+package api
+
+import (
+	"backend/storage"
+	"backend/types"
+	"crypto/subtle"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// requireAdmin only lets requests carrying `Authorization: Bearer
+// <ADMIN_TOKEN>` through to the admin router. With no admin token configured
+// there are no admin endpoints at all.
+func (q *QuizAPI) requireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if q.adminToken == "" {
+			http.NotFound(w, req)
+			return
+		}
+
+		given := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(given), []byte(q.adminToken)) != 1 {
+			log.Printf("bad admin token from %s", req.RemoteAddr)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, req)
+	})
+}
+
+type clipReport struct {
+	Difficulty  types.Difficulty `json:"difficulty"`
+	CorrectRate float64          `json:"correctRate"`
+
+	Clip         string         `json:"clip"`
+	Episode      types.Episode  `json:"episode"`
+	Served       int            `json:"served"`
+	Guessed      int            `json:"guessed"`
+	Correct      int            `json:"correct"`
+	WrongGuesses map[string]int `json:"wrongGuesses"`
+}
+
+// ClipStatsEndpoint reports how players do on each clip, hardest first.
+func (q *QuizAPI) ClipStatsEndpoint(w http.ResponseWriter, req *http.Request) {
+	stats, err := q.history.ClipStats()
+	if err != nil {
+		log.Printf("failed to get clip stats: %s", err)
+		http.Error(w, "failed to get clip stats", http.StatusInternalServerError)
+		return
+	}
+
+	current := q.manifests.Current()
+	report := make([]clipReport, 0, len(stats))
+	for _, stat := range stats {
+		row := clipReport{
+			CorrectRate:  stat.CorrectRate(),
+			Clip:         stat.Clip,
+			Episode:      stat.Episode,
+			Served:       stat.Served,
+			Guessed:      stat.Guessed,
+			Correct:      stat.Correct,
+			WrongGuesses: stat.WrongGuesses,
+		}
+
+		// which manifest it's in right now
+		for difficulty, manifest := range current.Manifests {
+			if _, ok := manifest.Lookup[stat.Clip]; ok {
+				row.Difficulty = difficulty
+				break
+			}
+		}
+
+		report = append(report, row)
+	}
+
+	sort.SliceStable(report, func(i, j int) bool { return report[i].CorrectRate < report[j].CorrectRate })
+
+	bytes, err := json.Marshal(&report)
+	if err != nil {
+		log.Printf("failed to marshall clip stats: %s", err)
+		http.Error(w, "failed to marshall clip stats!", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(bytes)
+}
+
+type reloadResult struct {
+	Loaded     time.Time                `json:"loaded"`
+	Clips      map[types.Difficulty]int `json:"clips"`
+	Categories int                      `json:"categories"`
+	Error      string                   `json:"error,omitempty"`
+}
+
+// ReloadEndpoint reloads the manifests. If the new ones are bad the old ones
+// stay and the reason comes back with a 422.
+func (q *QuizAPI) ReloadEndpoint(w http.ResponseWriter, req *http.Request) {
+	status := http.StatusOK
+	current, err := q.manifests.Reload()
+	if err != nil {
+		status = http.StatusUnprocessableEntity
+		current = q.manifests.Current()
+	}
+
+	result := reloadResult{
+		Loaded:     current.Loaded,
+		Clips:      make(map[types.Difficulty]int, len(current.Manifests)),
+		Categories: len(current.Categories.Categories),
+	}
+	if err != nil {
+		result.Error = err.Error()
+	}
+
+	for difficulty, manifest := range current.Manifests {
+		result.Clips[difficulty] = len(manifest.Keys)
+	}
+
+	bytes, err := json.Marshal(&result)
+	if err != nil {
+		log.Printf("failed to marshall reload result: %s", err)
+		http.Error(w, "failed to marshall reload result!", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(bytes)
+}
+
+type rateLimitReport struct {
+	Path    string  `json:"path"`
+	Rate    float64 `json:"rate"` // a second
+	Burst   int     `json:"burst"`
+	Limited int64   `json:"limited"`
+}
+
+// RateLimitsEndpoint reports how many requests each rate limit has turned
+// away since startup.
+func (q *QuizAPI) RateLimitsEndpoint(w http.ResponseWriter, req *http.Request) {
+	limited := q.limits.Limited()
+
+	report := []rateLimitReport{}
+	for path, policy := range q.limits.Policies() {
+		report = append(report, rateLimitReport{
+			Path:    path,
+			Rate:    policy.Rate,
+			Burst:   policy.Burst,
+			Limited: limited[path],
+		})
+	}
+
+	sort.Slice(report, func(i, j int) bool {
+		return report[i].Path < report[j].Path
+	})
+
+	bytes, err := json.Marshal(&report)
+	if err != nil {
+		log.Printf("failed to marshall rate limits: %s", err)
+		http.Error(w, "failed to marshall rate limits!", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(bytes)
+}
+
+func writeScoreRecords(w http.ResponseWriter, records interface{}) {
+	bytes, err := json.Marshal(records)
+	if err != nil {
+		log.Printf("failed to marshall scores: %s", err)
+		http.Error(w, "failed to marshall scores!", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(bytes)
+}
+
+// PendingScoresEndpoint lists the scores waiting for review, newest first.
+func (q *QuizAPI) PendingScoresEndpoint(w http.ResponseWriter, req *http.Request) {
+	limit, _ := strconv.Atoi(req.URL.Query().Get("limit"))
+
+	records, err := q.dataStore.ListScores(storage.ScoreFilter{Status: storage.StatusPending, Limit: limit})
+	if err != nil {
+		log.Printf("failed to list pending scores: %s", err)
+		http.Error(w, "failed to list pending scores", http.StatusInternalServerError)
+		return
+	}
+
+	writeScoreRecords(w, records)
+}
+
+// ReviewScoreEndpoint puts a pending score on the boards with
+// `?decision=approve`, or keeps it off them for good with `reject`.
+func (q *QuizAPI) ReviewScoreEndpoint(w http.ResponseWriter, req *http.Request) {
+	id := mux.Vars(req)["id"]
+
+	var status storage.ScoreStatus
+	switch req.URL.Query().Get("decision") {
+	case "approve":
+		status = storage.StatusAccepted
+	case "reject":
+		status = storage.StatusRejected
+	default:
+		http.Error(w, "decision must be approve or reject", http.StatusBadRequest)
+		return
+	}
+
+	records, err := q.dataStore.ListScores(storage.ScoreFilter{Id: id})
+	if err != nil {
+		log.Printf("failed to get score: %s", err)
+		http.Error(w, "failed to get score", http.StatusInternalServerError)
+		return
+	}
+
+	if len(records) == 0 || records[0].Status != storage.StatusPending {
+		http.Error(w, "no pending score with that id", http.StatusNotFound)
+		return
+	}
+
+	if err := q.dataStore.SetStatus(id, status); err != nil {
+		log.Printf("failed to review score: %s", err)
+		http.Error(w, "failed to review score", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("score %s reviewed: %s", id, status)
+	q.audit(req, "review", id, string(status))
+
+	record := records[0]
+	record.Status = status
+	writeScoreRecords(w, &record)
+}
@@ -1,22 +1,22 @@
 package api
 
 import (
+	"backend/catalog"
 	"backend/cryptopasta"
+	"backend/keys"
+	"backend/names"
+	"backend/ratelimit"
 	"backend/storage"
-	"crypto/rand"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"log"
-	"math/big"
-	pseudoRand "math/rand"
 	"net/http"
-	"path/filepath"
+	"strconv"
 	"time"
 
 	"backend/types"
 
-	"github.com/bits-and-blooms/bloom/v3"
 	"github.com/golang-jwt/jwt"
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
@@ -29,55 +29,108 @@ type TokenClaims struct {
 	Difficulty   types.Difficulty
 	Jti          string
 	Iat          int64
+
+	// which clip they're on in their run's shuffled order, see clipAt. The
+	// seed is encrypted so the order can't be worked out from the token.
+	Seed     []byte
+	Position int
+
+	// which game they're playing. Daily runs carry the day they started on,
+	// and only a player's first daily attempt of the day is Ranked. Challenge
+	// runs carry the challenge id, and Rules, the mode the challenger played.
+	Mode      types.Mode
+	Day       string
+	Challenge string
+	Rules     types.Mode
+	Ranked    bool
+
+	// how the run is going, for the modes that don't end on the first wrong
+	// answer. Started is when the first clip was served.
+	Answered int
+	Wrong    int
+	Lives    int
+	Started  int64
+
+	// time weighted scoring. Issued is when the current clip was handed out,
+	// in unix milliseconds, and Streak is how many they've got right in a row.
+	Points     int
+	Streak     int
+	BestStreak int
+	Issued     int64
+
+	// whether they've had the one near miss the save rule forgives
+	SaveUsed bool
+
+	// how many answers came quicker than Integrity.FastAnswer
+	Fast int
 }
 
 type QuizAPI struct {
-	burnedIds          *bloom.BloomFilter
-	burnedHighscoreIds *bloom.BloomFilter
-	burnedJtis         *bloom.BloomFilter
-
-	signatureKey  []byte
-	encryptionKey [32]byte
-
-	dataStore storage.Store
-	manifests map[types.Difficulty]types.RandomManifest
-
-	clipDir string
+	burned     storage.BurnRegistry
+	history    storage.HistoryStore
+	challenges storage.ChallengeStore
+	moderation storage.ModerationStore
+
+	keyRing  *keys.Ring
+	chooser  Chooser
+	curve    Curve
+	nearMiss NearMissRule
+	pow      ProofOfWork
+
+	integrity    Integrity
+	recentStarts startRates // for the proof of work
+	hourlyStarts startRates // for the integrity checks
+	clipRates    clipRates  // for perfectRunOdds
+
+	dataStore storage.ScoreStore
+	manifests *catalog.Source
+	limits    *ratelimit.Limiter
+	names     *names.Policy
+
+	clipDir     string
+	links       ClipLinks
+	wrapClips   bool // see clipWrap
+	adminToken  string
+	dailySecret []byte // nil if there is no daily mode
+
+	challengeSecret []byte // signs challenge codes
 
 	mux *mux.Router
 }
 
 var TotalCalls int = 0
 
-func NewQuizApi(manifests map[types.Difficulty]types.RandomManifest, dbPath, clipDir string) *QuizAPI {
+func NewQuizApi(manifests *catalog.Source, chooser Chooser, curve Curve, nearMiss NearMissRule, pow ProofOfWork, integrity Integrity, keyRing *keys.Ring, dataStore storage.ScoreStore, burned storage.BurnRegistry, history storage.HistoryStore, challenges storage.ChallengeStore, moderation storage.ModerationStore, limits *ratelimit.Limiter, namePolicy *names.Policy, clipDir string, links ClipLinks, wrapClips bool, adminToken string, dailySecret []byte, challengeSecret []byte) *QuizAPI {
 	api := QuizAPI{}
 
-	api.burnedIds = bloom.NewWithEstimates(10_000_000, 0.00000001)
-	api.burnedHighscoreIds = bloom.NewWithEstimates(10_000_000, 0.00000001)
-	api.burnedJtis = bloom.NewWithEstimates(10_000_000, 0.00000001)
+	api.burned = burned
+	api.history = history
+	api.challenges = challenges
+	api.moderation = moderation
 
-	nBig, err := rand.Int(rand.Reader, big.NewInt(27))
+	api.chooser = chooser
+	api.curve = curve
+	api.nearMiss = nearMiss
+	api.pow = pow
+	api.integrity = integrity
+	api.recentStarts.window = time.Minute
+	api.hourlyStarts.window = time.Hour
 
-	if err != nil {
-		panic(err)
-	}
-	pseudoRand.Seed(nBig.Int64())
-
-	temp := make([]byte, 32)
-
-	api.signatureKey = make([]byte, types.SIGNATURE_LENGTH)
-
-	rand.Read(api.signatureKey)
-	rand.Read(temp)
-	copy(api.encryptionKey[:], temp)
-
-	log.Printf("Signature Key: %s", base64.RawStdEncoding.EncodeToString(api.signatureKey))
-	log.Printf("Encryption Key: %s", base64.RawStdEncoding.EncodeToString(api.encryptionKey[:]))
+	api.keyRing = keyRing
+	log.Printf("Active Key: %s", keyRing.Current().Id)
 
 	api.clipDir = clipDir
+	api.links = links
+	api.wrapClips = wrapClips
+	api.adminToken = adminToken
+	api.dailySecret = dailySecret
+	api.challengeSecret = challengeSecret
 
 	api.manifests = manifests
-	api.dataStore.Init(dbPath)
+	api.dataStore = dataStore
+	api.limits = limits
+	limits.Sessions(api.sessionId)
+	api.names = namePolicy
 
 	api.mux = mux.NewRouter()
 
@@ -85,6 +138,10 @@ func NewQuizApi(manifests map[types.Difficulty]types.RandomManifest, dbPath, cli
 		TotalCalls += 1
 		api.GetClipEndpoint(w, req)
 	}).Methods(http.MethodPost)
+	api.mux.HandleFunc("/clipquiz/v1/pow", func(w http.ResponseWriter, req *http.Request) {
+		TotalCalls += 1
+		api.PowEndpoint(w, req)
+	}).Methods(http.MethodGet)
 	api.mux.HandleFunc("/clipquiz/v1/highscore", func(w http.ResponseWriter, req *http.Request) {
 		TotalCalls += 1
 		api.RegisterHighscoreEndpoint(w, req)
@@ -93,10 +150,78 @@ func NewQuizApi(manifests map[types.Difficulty]types.RandomManifest, dbPath, cli
 		TotalCalls += 1
 		api.GetHighScoresEndpoint(w, req)
 	}).Methods(http.MethodGet)
+	api.mux.HandleFunc("/clipquiz/v1/leaderboard", func(w http.ResponseWriter, req *http.Request) {
+		TotalCalls += 1
+		api.LeaderboardEndpoint(w, req)
+	}).Methods(http.MethodGet)
+	api.mux.HandleFunc("/clipquiz/v1/leaderboard/rank", func(w http.ResponseWriter, req *http.Request) {
+		TotalCalls += 1
+		api.RankEndpoint(w, req)
+	}).Methods(http.MethodGet)
+	api.mux.HandleFunc("/clipquiz/v1/categories", func(w http.ResponseWriter, req *http.Request) {
+		TotalCalls += 1
+		api.GetCategoriesEndpoint(w, req)
+	}).Methods(http.MethodGet)
+	api.mux.HandleFunc("/clipquiz/v1/challenge", func(w http.ResponseWriter, req *http.Request) {
+		TotalCalls += 1
+		api.CreateChallengeEndpoint(w, req)
+	}).Methods(http.MethodPost)
+	api.mux.HandleFunc("/clipquiz/v1/challenge", func(w http.ResponseWriter, req *http.Request) {
+		TotalCalls += 1
+		api.GetChallengeEndpoint(w, req)
+	}).Methods(http.MethodGet)
+	api.mux.HandleFunc("/clipquiz/v1/challenge/result", func(w http.ResponseWriter, req *http.Request) {
+		TotalCalls += 1
+		api.RegisterChallengeResultEndpoint(w, req)
+	}).Methods(http.MethodPost)
+	api.mux.HandleFunc("/clipquiz/v1/daily/recap", func(w http.ResponseWriter, req *http.Request) {
+		TotalCalls += 1
+		api.DailyRecapEndpoint(w, req)
+	}).Methods(http.MethodGet)
+	api.mux.HandleFunc("/clipquiz/v1/daily/clip", func(w http.ResponseWriter, req *http.Request) {
+		TotalCalls += 1
+		api.DailyClipEndpoint(w, req)
+	}).Methods(http.MethodGet)
+	api.mux.HandleFunc(MEDIA_PATH+"{link}", func(w http.ResponseWriter, req *http.Request) {
+		TotalCalls += 1
+		api.ClipMediaEndpoint(w, req)
+	}).Methods(http.MethodGet, http.MethodHead)
+	api.mux.HandleFunc("/clipquiz/v2/run", func(w http.ResponseWriter, req *http.Request) {
+		TotalCalls += 1
+		api.StartRunV2Endpoint(w, req)
+	}).Methods(http.MethodPost)
+	api.mux.HandleFunc("/clipquiz/v2/guess", func(w http.ResponseWriter, req *http.Request) {
+		TotalCalls += 1
+		api.GuessV2Endpoint(w, req)
+	}).Methods(http.MethodPost)
+
+	admin := api.mux.PathPrefix("/clipquiz/v1/admin").Subrouter()
+	admin.Use(api.requireAdmin)
+	admin.HandleFunc("/clipstats", api.ClipStatsEndpoint).Methods(http.MethodGet)
+	admin.HandleFunc("/reload", api.ReloadEndpoint).Methods(http.MethodPost)
+	admin.HandleFunc("/pending", api.PendingScoresEndpoint).Methods(http.MethodGet)
+	admin.HandleFunc("/pending/{id}", api.ReviewScoreEndpoint).Methods(http.MethodPost)
+	admin.HandleFunc("/ratelimits", api.RateLimitsEndpoint).Methods(http.MethodGet)
+	admin.HandleFunc("/scores", api.ListScoresEndpoint).Methods(http.MethodGet)
+	admin.HandleFunc("/scores/{id}", api.DeleteScoreEndpoint).Methods(http.MethodDelete)
+	admin.HandleFunc("/scores/{id}/hide", api.HideScoreEndpoint(true)).Methods(http.MethodPost)
+	admin.HandleFunc("/scores/{id}/unhide", api.HideScoreEndpoint(false)).Methods(http.MethodPost)
+	admin.HandleFunc("/scores/{id}/rename", api.RenameScoreEndpoint).Methods(http.MethodPost)
+	admin.HandleFunc("/bans", api.BansEndpoint).Methods(http.MethodGet)
+	admin.HandleFunc("/bans", api.BanEndpoint).Methods(http.MethodPost)
+	admin.HandleFunc("/bans", api.UnbanEndpoint).Methods(http.MethodDelete)
+	admin.HandleFunc("/audit", api.AuditLogEndpoint).Methods(http.MethodGet)
+
 	api.mux.HandleFunc("/", func(rw http.ResponseWriter, r *http.Request) {
 		rw.Header().Add("Expires", time.Now().Add(time.Minute*15).Format(http.TimeFormat))
 		rw.Write([]byte("All Systems Operational Captain\r\n"))
-		rw.Write([]byte(fmt.Sprintf("total calls: %d", TotalCalls)))
+		rw.Write([]byte(fmt.Sprintf("total calls: %d\r\n", TotalCalls)))
+
+		var limited int64
+		for _, count := range api.limits.Limited() {
+			limited += count
+		}
+		rw.Write([]byte(fmt.Sprintf("rate limited: %d", limited)))
 	})
 	return &api
 }
@@ -105,13 +230,54 @@ func (q *QuizAPI) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	q.mux.ServeHTTP(w, req)
 }
 
+// tokenKey is the key that signed token, going by its kid.
+func (q *QuizAPI) tokenKey(token *jwt.Token) (keys.Key, error) {
+	if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+		return keys.Key{}, fmt.Errorf("wrong algorithm: %v", token.Header["alg"])
+	}
+
+	kid, ok := token.Header["kid"].(string)
+	if !ok {
+		return keys.Key{}, fmt.Errorf("kid not a string?")
+	}
+
+	key, ok := q.keyRing.Lookup(kid)
+	if !ok {
+		return keys.Key{}, fmt.Errorf("unknown or expired key: %s", kid)
+	}
+
+	return key, nil
+}
+
+// sessionId is the run id in req's token, for the per session rate limits.
+// It's empty if there isn't a valid token, those requests won't get far
+// anyway.
+func (q *QuizAPI) sessionId(req *http.Request) string {
+	auth := req.Header.Get("Auth-Token")
+	if auth == "" {
+		return ""
+	}
+
+	token, err := jwt.Parse(auth, func(token *jwt.Token) (interface{}, error) {
+		key, err := q.tokenKey(token)
+		return key.SignatureKey, err
+	})
+	if err != nil || !token.Valid {
+		return ""
+	}
+
+	claims, _ := token.Claims.(jwt.MapClaims)
+	id, _ := claims["id"].(string)
+	return id
+}
+
 func (q *QuizAPI) parseFromJwt(authToken string) (TokenClaims, error) {
-	token, err := jwt.Parse(authToken, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("wrong algorithm: %v", token.Header["alg"])
-		}
+	var key keys.Key
 
-		return q.signatureKey, nil
+	token, err := jwt.Parse(authToken, func(token *jwt.Token) (interface{}, error) {
+		var err error
+		key, err = q.tokenKey(token)
+		return key.SignatureKey, err
 	})
 
 	if err != nil {
@@ -150,18 +316,32 @@ func (q *QuizAPI) parseFromJwt(authToken string) (TokenClaims, error) {
 		parsed.Difficulty = types.Difficulty(diffString)
 
 		// parsed.Correct is a base64 encoded encrypted UTF-8 string
-		encBytes, err := base64.StdEncoding.DecodeString(parsed.Correct)
-		if err != nil {
-			return TokenClaims{}, fmt.Errorf("failed to decode correct: base64 decoding error %s", err)
-		}
-
-		previousCorrectBytes, err := cryptopasta.Decrypt(encBytes, &q.encryptionKey)
+		previousCorrectBytes, err := decryptClaim(&key, parsed.Correct)
 		if err != nil {
 			return TokenClaims{}, fmt.Errorf("failed to decrypt correct: %s", err)
 		}
 
 		parsed.Correct = string(previousCorrectBytes)
 
+		// tokens from before runs were shuffled don't have these, they get a
+		// fresh shuffle
+		if encSeed, ok := claims["seed"].(string); ok {
+			if parsed.Seed, err = decryptClaim(&key, encSeed); err != nil {
+				return TokenClaims{}, fmt.Errorf("failed to decrypt seed: %s", err)
+			}
+
+			var positionFloat float64
+			if positionFloat, ok = claims["position"].(float64); !ok {
+				return TokenClaims{}, fmt.Errorf("position not a float?")
+			}
+
+			parsed.Position = int(positionFloat)
+		} else {
+			if parsed.Seed, err = q.chooser.NewSeed(); err != nil {
+				return TokenClaims{}, err
+			}
+		}
+
 		var iatFloat float64
 		if iatFloat, ok = claims["iat"].(float64); !ok {
 			return TokenClaims{}, fmt.Errorf("iat not a float?")
@@ -169,9 +349,86 @@ func (q *QuizAPI) parseFromJwt(authToken string) (TokenClaims, error) {
 
 		parsed.Iat = int64(iatFloat)
 
+		// tokens from before there were modes are ranked classic runs
+		parsed.Mode = types.Classic
+		parsed.Ranked = true
+		if mode, ok := claims["mode"].(string); ok {
+			parsed.Mode = types.Mode(mode)
+
+			if parsed.Day, ok = claims["day"].(string); !ok {
+				return TokenClaims{}, fmt.Errorf("day not a string?")
+			}
+
+			if parsed.Challenge, ok = claims["challenge"].(string); !ok {
+				return TokenClaims{}, fmt.Errorf("challenge not a string?")
+			}
+
+			if parsed.Ranked, ok = claims["ranked"].(bool); !ok {
+				return TokenClaims{}, fmt.Errorf("ranked not a bool?")
+			}
+
+			// challenge tokens from before they kept the challenger's mode
+			// play sudden death
+			if rules, ok := claims["rules"].(string); ok {
+				parsed.Rules = types.Mode(rules)
+			}
+		}
+
+		// tokens from before lives, rounds and blitz don't have these
+		if parsed.Answered, err = optionalInt(claims, "answered"); err != nil {
+			return TokenClaims{}, err
+		}
+
+		if parsed.Wrong, err = optionalInt(claims, "wrong"); err != nil {
+			return TokenClaims{}, err
+		}
+
+		if parsed.Lives, err = optionalInt(claims, "lives"); err != nil {
+			return TokenClaims{}, err
+		}
+
+		if parsed.Points, err = optionalInt(claims, "points"); err != nil {
+			return TokenClaims{}, err
+		}
+
+		if parsed.Streak, err = optionalInt(claims, "streak"); err != nil {
+			return TokenClaims{}, err
+		}
+
+		if parsed.BestStreak, err = optionalInt(claims, "bestStreak"); err != nil {
+			return TokenClaims{}, err
+		}
+
+		if parsed.Fast, err = optionalInt(claims, "fast"); err != nil {
+			return TokenClaims{}, err
+		}
+
+		if saveUsed, ok := claims["saveUsed"]; ok {
+			if parsed.SaveUsed, ok = saveUsed.(bool); !ok {
+				return TokenClaims{}, fmt.Errorf("saveUsed not a bool?")
+			}
+		}
+
+		issued, err := optionalInt(claims, "issued")
+		if err != nil {
+			return TokenClaims{}, err
+		}
+
+		parsed.Issued = int64(issued)
+
+		started, err := optionalInt(claims, "started")
+		if err != nil {
+			return TokenClaims{}, err
+		}
+
+		parsed.Started = int64(started)
+		if parsed.Started == 0 {
+			parsed.Started = parsed.Iat
+		}
+
 		iat := time.Unix(int64(parsed.Iat), 0)
 
-		if time.Since(iat) > time.Minute*15 {
+		if time.Since(iat) > types.TOKEN_LIFETIME {
 			return TokenClaims{}, fmt.Errorf("token expired")
 		}
 		return parsed, nil
@@ -181,15 +438,21 @@ func (q *QuizAPI) parseFromJwt(authToken string) (TokenClaims, error) {
 }
 
 func (q *QuizAPI) mintToken(claims TokenClaims) (string, error) {
+	key := q.keyRing.Current()
+
 	// encrypt correct
 	var err error
-	encryptedCorrect, err := cryptopasta.Encrypt([]byte(claims.Correct), &q.encryptionKey)
+	claims.Correct, err = encryptClaim(&key, []byte(claims.Correct))
 
 	if err != nil {
 		return "", fmt.Errorf("failed to encrypt correct: %s", err)
 	}
 
-	claims.Correct = base64.StdEncoding.EncodeToString(encryptedCorrect)
+	encryptedSeed, err := encryptClaim(&key, claims.Seed)
+
+	if err != nil {
+		return "", fmt.Errorf("failed to encrypt seed: %s", err)
+	}
 
 	jti := uuid.New().String()
 
@@ -201,9 +464,27 @@ func (q *QuizAPI) mintToken(claims TokenClaims) (string, error) {
 		"difficulty":   claims.Difficulty,
 		"jti":          jti,
 		"iat":          time.Now().Unix(),
+		"seed":         encryptedSeed,
+		"position":     claims.Position,
+		"mode":         claims.Mode,
+		"day":          claims.Day,
+		"challenge":    claims.Challenge,
+		"rules":        claims.Rules,
+		"ranked":       claims.Ranked,
+		"answered":     claims.Answered,
+		"wrong":        claims.Wrong,
+		"lives":        claims.Lives,
+		"started":      claims.Started,
+		"points":       claims.Points,
+		"streak":       claims.Streak,
+		"bestStreak":   claims.BestStreak,
+		"issued":       claims.Issued,
+		"saveUsed":     claims.SaveUsed,
+		"fast":         claims.Fast,
 	})
+	token.Header["kid"] = key.Id
 
-	tokenStr, err := token.SignedString(q.signatureKey)
+	tokenStr, err := token.SignedString(key.SignatureKey)
 
 	if err != nil {
 		return "", fmt.Errorf("failed to sign token: %s", err)
@@ -212,101 +493,142 @@ func (q *QuizAPI) mintToken(claims TokenClaims) (string, error) {
 	return tokenStr, nil
 }
 
-func (q *QuizAPI) randomClip(diff types.Difficulty) (string, types.Episode) {
-	manifest := q.manifests[diff]
-	randomIndex := pseudoRand.Intn(len(manifest.Keys))
-	clipName := manifest.Keys[randomIndex]
-	correctEpisode := manifest.Lookup[clipName]
+// millis is t in unix milliseconds
+func millis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
 
-	return clipName, correctEpisode
+// sinceMillis is how long it's been since ms, in unix milliseconds
+func sinceMillis(ms int64) time.Duration {
+	return time.Since(time.Unix(0, ms*int64(time.Millisecond)))
 }
 
-func (q *QuizAPI) GetClipEndpoint(w http.ResponseWriter, req *http.Request) {
-	auth := req.Header.Get("Auth-Token")
+// optionalInt is a number claim that older tokens might not have, which
+// counts as 0.
+func optionalInt(claims jwt.MapClaims, name string) (int, error) {
+	value, ok := claims[name]
+	if !ok {
+		return 0, nil
+	}
 
-	var claims TokenClaims
-	var err error
+	number, ok := value.(float64)
+	if !ok {
+		return 0, fmt.Errorf("%s not a float?", name)
+	}
 
-	if auth == "" {
-		// they're just starting out
-		claims.Id = uuid.New().String()
+	return int(number), nil
+}
 
-		diff := req.URL.Query().Get("difficulty")
+// encryptClaim encrypts plaintext with key and base64 encodes it so it can
+// go in a token.
+func encryptClaim(key *keys.Key, plaintext []byte) (string, error) {
+	encrypted, err := cryptopasta.Encrypt(plaintext, &key.EncryptionKey)
+	if err != nil {
+		return "", err
+	}
 
-		if diff == "" || (diff != string(types.Easy) && diff != string(types.Medium) && diff != string(types.Hard) && diff != string(types.Legend)) {
-			log.Printf("bad params on new request")
-			http.Error(w, "failed to parse url", http.StatusBadRequest)
-			return
-		}
+	return base64.StdEncoding.EncodeToString(encrypted), nil
+}
 
-		claims.Difficulty = types.Difficulty(diff)
+func decryptClaim(key *keys.Key, claim string) ([]byte, error) {
+	encBytes, err := base64.StdEncoding.DecodeString(claim)
+	if err != nil {
+		return nil, fmt.Errorf("base64 decoding error %s", err)
+	}
 
-	} else {
-		claims, err = q.parseFromJwt(auth)
+	return cryptopasta.Decrypt(encBytes, &key.EncryptionKey)
+}
 
-		if err != nil {
-			log.Printf("Token Parse Error: %s", err)
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
-		}
+// clipAt is the clip at position in the run's shuffled order of manifest.
+// Every run walks its own permutation of the clips, so nothing repeats until
+// they've heard them all. ok is false once they have.
+func (q *QuizAPI) clipAt(manifest types.RandomManifest, seed []byte, position int) (clipName string, correctEpisode types.Episode, ok bool) {
+	if position >= len(manifest.Keys) {
+		return "", "", false
+	}
 
-		if q.burnedIds.TestString(claims.Id) {
-			log.Printf("id has been burned")
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
-		}
+	order := q.chooser.Shuffle(seed, len(manifest.Keys))
+	clipName = manifest.Keys[order[position]]
+	correctEpisode = manifest.Lookup[clipName]
 
-		if q.burnedJtis.TestString(claims.Jti) {
-			log.Printf("jti has been burned")
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
-		}
+	return clipName, correctEpisode, true
+}
 
-		q.burnedJtis.AddString(claims.Jti)
+func (q *QuizAPI) GetClipEndpoint(w http.ResponseWriter, req *http.Request) {
+	auth := req.Header.Get("Auth-Token")
 
-		// parse out their guess
-		guess := req.URL.Query().Get("guess")
+	var t turn
+	var apiErr *apiError
+	if auth == "" {
+		// they're just starting out
+		t, apiErr = q.startTurn(req)
+	} else {
+		t, apiErr = q.guessTurn(auth, req.URL.Query().Get("guess"))
+	}
 
-		if guess == "" {
-			log.Printf("no guess")
-			http.Error(w, "No guess", http.StatusBadRequest)
-			return
-		}
+	if apiErr != nil {
+		http.Error(w, apiErr.message, apiErr.status)
+		return
+	}
 
-		if guess != claims.Correct {
-			// that's all folks!
-			// burn their id
-			q.burnedIds.AddString(claims.Id)
-			// remind them of their auth token
-			w.Header().Add("Auth-Token", auth)
-			// use 404 to indicate that they're done
-			w.WriteHeader(http.StatusNotFound)
+	w.Header().Add("Auth-Token", t.token)
+	writeStats(w, t.stats)
 
-			// write the correct answer
-			w.Write([]byte(claims.Correct))
+	// the same clip, for clients that would rather fetch it separately
+	if t.link != "" {
+		w.Header().Set("Clip-Url", t.link)
+	}
 
-			return
-		}
-		claims.CurrentScore += 1
+	if t.outcome == nil && t.claims.Mode == types.Daily {
+		w.Header().Set("Ranked", strconv.FormatBool(t.claims.Ranked))
+		w.Header().Set("Player-Token", t.player)
 	}
 
-	// send a new file
-	fileName, episode := q.randomClip(claims.Difficulty)
-	claims.Correct = string(episode)
+	if t.lost() {
+		w.Header().Set("Near-Miss", strconv.FormatBool(t.outcome.NearMiss))
 
-	// mint a new token
-	auth, err = q.mintToken(claims)
-	if err != nil {
-		log.Printf("token creation error: %s", err)
-		http.Error(w, "could not issue token", http.StatusInternalServerError)
+		// use 404 to indicate that they're done
+		w.WriteHeader(http.StatusNotFound)
+
+		// write the correct answer
+		w.Write([]byte(t.answer))
 		return
 	}
 
-	w.Header().Add("Auth-Token", auth)
+	if t.reason != "" {
+		// done, but not because they got one wrong
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	q.serveClip(w, req, t.clip)
+}
+
+// checkName puts name through the name policy, writing the rejection with
+// its reason if it doesn't pass.
+func (q *QuizAPI) checkName(w http.ResponseWriter, name string) (string, bool) {
+	checked, err := q.names.Check(name)
+	if err != nil {
+		reason := ""
+		if rejection, ok := err.(*names.Rejection); ok {
+			reason = string(rejection.Reason)
+		}
+
+		log.Printf("name rejected: %s", err)
+		writeJSON(w, http.StatusBadRequest, errorDoc{Error: err.Error(), Reason: reason})
+		return "", false
+	}
+
+	return checked, true
+}
 
-	// serve the file
-	filePath := filepath.Join(q.clipDir, fileName) + ".enc"
-	http.ServeFile(w, req, filePath)
+// registeredDoc is how registering a score went. The id is for looking up
+// its rank later, see RankEndpoint.
+type registeredDoc struct {
+	Id      string         `json:"id"`
+	Pending bool           `json:"pending,omitempty"`
+	Message string         `json:"message,omitempty"`
+	Ranks   map[string]int `json:"ranks,omitempty"` // by window, see ranks
 }
 
 func (q *QuizAPI) RegisterHighscoreEndpoint(w http.ResponseWriter, req *http.Request) {
@@ -325,30 +647,59 @@ func (q *QuizAPI) RegisterHighscoreEndpoint(w http.ResponseWriter, req *http.Req
 		return
 	}
 
-	if q.burnedHighscoreIds.TestString(claims.Id) {
-		log.Printf("attempted to register with burned token")
-		http.Error(w, "invalid token", http.StatusUnauthorized)
+	if !claims.Ranked {
+		http.Error(w, "that run isn't ranked", http.StatusForbidden)
 		return
 	}
 
-	q.burnedHighscoreIds.AddString(claims.Id)
+	// before burning, so they can try another name
+	name, ok := q.checkName(w, req.URL.Query().Get("name"))
+	if !ok || q.banned(w, claims.Id, name) {
+		return
+	}
 
-	name := req.URL.Query().Get("name")
+	alreadyRegistered, err := q.burned.Burn(storage.BurnedHighscoreId, claims.Id, time.Now().Add(types.TOKEN_LIFETIME))
+	if err != nil {
+		log.Printf("failed to burn highscore id: %s", err)
+		http.Error(w, "could not check token", http.StatusInternalServerError)
+		return
+	}
 
-	if name == "" || len(name) > 20 {
-		log.Printf("invalid name attempted")
-		http.Error(w, "that's not a valid name", http.StatusBadRequest)
+	if alreadyRegistered {
+		log.Printf("attempted to register with burned token")
+		http.Error(w, "invalid token", http.StatusUnauthorized)
 		return
 	}
 
-	err = q.dataStore.RegisterScore(claims.Id, name, claims.Difficulty, claims.CurrentScore)
+	flags := q.integrityFlags(req, claims)
+	status := scoreStatus(flags)
+
+	entry := storage.ScoreEntry{
+		Id:         claims.Id,
+		Name:       name,
+		Difficulty: claims.Difficulty,
+		Mode:       claims.Mode,
+		Day:        claims.Day,
+		Score:      claims.CurrentScore,
+		Points:     claims.Points,
+		Streak:     claims.BestStreak,
+		Status:     status,
+		Flags:      flags,
+	}
 
-	if err != nil {
+	if err = q.dataStore.RegisterScore(entry); err != nil {
 		log.Printf("failed to register score: %s", err)
 		http.Error(w, "failed to register score", http.StatusInternalServerError)
 		return
 	}
-	w.WriteHeader(http.StatusCreated)
+
+	if status == storage.StatusPending {
+		log.Printf("score for run %s is pending review: %v", claims.Id, flags)
+		writeJSON(w, http.StatusAccepted, registeredDoc{Id: claims.Id, Pending: true, Message: "your score will show up once it's been checked"})
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, registeredDoc{Id: claims.Id, Ranks: q.ranks(entry)})
 }
 
 func (q *QuizAPI) GetHighScoresEndpoint(w http.ResponseWriter, req *http.Request) {
@@ -370,3 +721,16 @@ func (q *QuizAPI) GetHighScoresEndpoint(w http.ResponseWriter, req *http.Request
 	w.Header().Add("Expires", time.Now().Add(time.Second*24).Format(http.TimeFormat))
 	w.Write(bytes)
 }
+
+func (q *QuizAPI) GetCategoriesEndpoint(w http.ResponseWriter, req *http.Request) {
+	bytes, err := json.Marshal(q.manifests.Current().Categories)
+	if err != nil {
+		log.Printf("failed to marshall categories: %s", err)
+		http.Error(w, "failed to marshall categories!", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Add("Expires", time.Now().Add(time.Hour).Format(http.TimeFormat))
+	w.Write(bytes)
+}
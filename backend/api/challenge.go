@@ -0,0 +1,296 @@
+package api
+
+import (
+	"backend/storage"
+	"backend/types"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const CHALLENGE_ID_LENGTH = 16
+
+// challengeSignature is what stops people from making up codes.
+func challengeSignature(secret []byte, id string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte("challenge|" + id))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// challengeCode is the shareable form of a challenge id, `<id>.<sig>`. It's
+// signed with the challenge secret rather than a rotating key, so codes keep
+// working for as long as the challenge is around.
+func (q *QuizAPI) challengeCode(id string) string {
+	return fmt.Sprintf("%s.%s", id, challengeSignature(q.challengeSecret, id))
+}
+
+// challengeId checks a code's signature and returns the challenge id in it.
+func (q *QuizAPI) challengeId(code string) (string, error) {
+	parts := strings.Split(code, ".")
+
+	switch len(parts) {
+	case 2:
+		if !hmac.Equal([]byte(parts[1]), []byte(challengeSignature(q.challengeSecret, parts[0]))) {
+			return "", fmt.Errorf("bad challenge signature")
+		}
+	case 3:
+		// codes from before there was a challenge secret are `<id>.<kid>.<sig>`,
+		// and only work while that key is around
+		key, ok := q.keyRing.Lookup(parts[1])
+		if !ok {
+			return "", fmt.Errorf("unknown or expired key: %s", parts[1])
+		}
+
+		if !hmac.Equal([]byte(parts[2]), []byte(challengeSignature(key.SignatureKey, parts[0]))) {
+			return "", fmt.Errorf("bad challenge signature")
+		}
+	default:
+		return "", fmt.Errorf("malformed challenge code")
+	}
+
+	return parts[0], nil
+}
+
+// startChallenge sets up claims to replay the challenge in code, under the
+// same rules the challenger played by. Challenge runs aren't ranked, the
+// challenger already knows the answers.
+func (q *QuizAPI) startChallenge(code string, claims *TokenClaims) error {
+	id, err := q.challengeId(code)
+	if err != nil {
+		return err
+	}
+
+	challenge, err := q.challenges.GetChallenge(id)
+	if err != nil {
+		return err
+	}
+
+	claims.Mode = types.Challenge
+	claims.Challenge = challenge.Id
+	claims.Rules = challenge.Mode
+	claims.Difficulty = challenge.Difficulty
+	claims.Seed = challenge.Seed
+	claims.Ranked = false
+
+	if claims.Rules == types.Lives {
+		claims.Lives = types.LIVES
+	}
+
+	return nil
+}
+
+// finishedClaims is the claims from the token in req, which has to be from a
+// run that is over. It writes the error response if not.
+func (q *QuizAPI) finishedClaims(w http.ResponseWriter, req *http.Request) (TokenClaims, bool) {
+	auth := req.Header.Get("Auth-Token")
+
+	if auth == "" {
+		http.Error(w, "you need a token", http.StatusUnauthorized)
+		return TokenClaims{}, false
+	}
+
+	claims, err := q.parseFromJwt(auth)
+	if err != nil {
+		log.Printf("failed to validate token: %v", err)
+		http.Error(w, "invalid token", http.StatusUnauthorized)
+		return TokenClaims{}, false
+	}
+
+	over, err := q.burned.IsBurned(storage.BurnedId, claims.Id)
+	if err != nil {
+		log.Printf("failed to check id: %s", err)
+		http.Error(w, "could not check token", http.StatusInternalServerError)
+		return TokenClaims{}, false
+	}
+
+	if !over {
+		http.Error(w, "finish your run first", http.StatusConflict)
+		return TokenClaims{}, false
+	}
+
+	return claims, true
+}
+
+type challengeEntry struct {
+	Name  string `json:"name"`
+	Score int    `json:"score"`
+}
+
+type headToHead struct {
+	Code       string                    `json:"code"`
+	Difficulty types.Difficulty          `json:"difficulty"`
+	Mode       types.Mode                `json:"mode"`
+	Challenger challengeEntry            `json:"challenger"`
+	Results    []storage.ChallengeResult `json:"results"`
+}
+
+// writeHeadToHead sends the challenger's score and everyone's attempts at it.
+func (q *QuizAPI) writeHeadToHead(w http.ResponseWriter, status int, code string, challenge storage.Challenge) {
+	results, err := q.challenges.GetResults(challenge.Id)
+	if err != nil {
+		log.Printf("failed to get challenge results: %s", err)
+		http.Error(w, "failed to get challenge results!", http.StatusInternalServerError)
+		return
+	}
+
+	bytes, err := json.Marshal(&headToHead{
+		Code:       code,
+		Difficulty: challenge.Difficulty,
+		Mode:       challenge.Mode,
+		Challenger: challengeEntry{Name: challenge.Name, Score: challenge.Score},
+		Results:    results,
+	})
+	if err != nil {
+		log.Printf("failed to marshall challenge: %s", err)
+		http.Error(w, "failed to marshall challenge!", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(bytes)
+}
+
+// CreateChallengeEndpoint turns a finished run into a challenge code that
+// replays its clips for whoever it's shared with.
+func (q *QuizAPI) CreateChallengeEndpoint(w http.ResponseWriter, req *http.Request) {
+	claims, ok := q.finishedClaims(w, req)
+	if !ok {
+		return
+	}
+
+	// today's daily clips would give away the answers
+	if claims.Mode == types.Daily {
+		http.Error(w, "daily runs can't be challenges", http.StatusBadRequest)
+		return
+	}
+
+	name, ok := q.checkName(w, req.URL.Query().Get("name"))
+	if !ok || q.banned(w, claims.Id, name) {
+		return
+	}
+
+	id := make([]byte, CHALLENGE_ID_LENGTH)
+	if _, err := rand.Read(id); err != nil {
+		log.Printf("failed to make challenge id: %s", err)
+		http.Error(w, "could not create challenge", http.StatusInternalServerError)
+		return
+	}
+
+	challenge, err := q.challenges.CreateChallenge(storage.Challenge{
+		Id:         hex.EncodeToString(id),
+		RunId:      claims.Id,
+		Difficulty: claims.Difficulty,
+		Mode:       claims.rules(),
+		Seed:       claims.Seed,
+		Name:       name,
+		Score:      claims.CurrentScore,
+		Created:    time.Now(),
+	})
+	if err != nil {
+		log.Printf("failed to create challenge: %s", err)
+		http.Error(w, "could not create challenge", http.StatusInternalServerError)
+		return
+	}
+
+	q.writeHeadToHead(w, http.StatusCreated, q.challengeCode(challenge.Id), challenge)
+}
+
+// getChallenge loads the challenge for the code in the query, writing the
+// error response if it can't.
+func (q *QuizAPI) getChallenge(w http.ResponseWriter, req *http.Request) (string, storage.Challenge, bool) {
+	code := req.URL.Query().Get("code")
+
+	id, err := q.challengeId(code)
+	if err != nil {
+		log.Printf("bad challenge code: %s", err)
+		http.Error(w, "that's not a valid challenge", http.StatusNotFound)
+		return "", storage.Challenge{}, false
+	}
+
+	challenge, err := q.challenges.GetChallenge(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "that's not a valid challenge", http.StatusNotFound)
+		return "", storage.Challenge{}, false
+	} else if err != nil {
+		log.Printf("failed to get challenge: %s", err)
+		http.Error(w, "failed to get challenge!", http.StatusInternalServerError)
+		return "", storage.Challenge{}, false
+	}
+
+	return code, challenge, true
+}
+
+// GetChallengeEndpoint is the head to head for a challenge code.
+func (q *QuizAPI) GetChallengeEndpoint(w http.ResponseWriter, req *http.Request) {
+	code, challenge, ok := q.getChallenge(w, req)
+	if !ok {
+		return
+	}
+
+	q.writeHeadToHead(w, http.StatusOK, code, challenge)
+}
+
+// RegisterChallengeResultEndpoint puts a finished challenge run up against
+// the challenger, like RegisterHighscoreEndpoint does for the leaderboard.
+func (q *QuizAPI) RegisterChallengeResultEndpoint(w http.ResponseWriter, req *http.Request) {
+	claims, ok := q.finishedClaims(w, req)
+	if !ok {
+		return
+	}
+
+	if claims.Mode != types.Challenge {
+		http.Error(w, "that run wasn't a challenge", http.StatusBadRequest)
+		return
+	}
+
+	challenge, err := q.challenges.GetChallenge(claims.Challenge)
+	if err != nil {
+		log.Printf("failed to get challenge: %s", err)
+		http.Error(w, "failed to get challenge!", http.StatusInternalServerError)
+		return
+	}
+
+	name, ok := q.checkName(w, req.URL.Query().Get("name"))
+	if !ok || q.banned(w, claims.Id, name) {
+		return
+	}
+
+	alreadyRegistered, err := q.burned.Burn(storage.BurnedHighscoreId, claims.Id, time.Now().Add(types.TOKEN_LIFETIME))
+	if err != nil {
+		log.Printf("failed to burn highscore id: %s", err)
+		http.Error(w, "could not check token", http.StatusInternalServerError)
+		return
+	}
+
+	if alreadyRegistered {
+		log.Printf("attempted to register with burned token")
+		http.Error(w, "invalid token", http.StatusUnauthorized)
+		return
+	}
+
+	err = q.challenges.RecordResult(storage.ChallengeResult{
+		ChallengeId: challenge.Id,
+		RunId:       claims.Id,
+		Name:        name,
+		Score:       claims.CurrentScore,
+		Finished:    time.Now(),
+	})
+	if err != nil {
+		log.Printf("failed to record challenge result: %s", err)
+		http.Error(w, "failed to record result", http.StatusInternalServerError)
+		return
+	}
+
+	q.writeHeadToHead(w, http.StatusCreated, q.challengeCode(challenge.Id), challenge)
+}
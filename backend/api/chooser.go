@@ -0,0 +1,112 @@
+package api
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	pseudoRand "math/rand"
+	"sync"
+)
+
+// Chooser decides what order a run hears the clips in. A run only carries
+// its seed, so Shuffle must always give the same order for the same seed.
+type Chooser interface {
+	NewSeed() ([]byte, error)
+	// Shuffle returns a permutation of [0, n) for seed.
+	Shuffle(seed []byte, n int) []int
+}
+
+// CryptoChooser is the production Chooser. Seeds come from crypto/rand and
+// the shuffle is driven by AES-CTR keyed by the seed, so knowing a few clips
+// from a run doesn't tell you the rest.
+type CryptoChooser struct{}
+
+const SEED_LENGTH = 32
+
+func (CryptoChooser) NewSeed() ([]byte, error) {
+	seed := make([]byte, SEED_LENGTH)
+	if _, err := rand.Read(seed); err != nil {
+		return nil, fmt.Errorf("failed to make seed: %s", err)
+	}
+	return seed, nil
+}
+
+// keystream hands out uniformly random numbers from an AES-CTR stream.
+type keystream struct {
+	stream cipher.Stream
+	buf    [8]byte
+}
+
+func (k *keystream) uint64() uint64 {
+	k.buf = [8]byte{}
+	k.stream.XORKeyStream(k.buf[:], k.buf[:])
+	return binary.BigEndian.Uint64(k.buf[:])
+}
+
+// intn is uniform in [0, n), rejecting the top of the range so small n
+// aren't biased.
+func (k *keystream) intn(n int) int {
+	max := ^uint64(0) - ^uint64(0)%uint64(n)
+	for {
+		v := k.uint64()
+		if v < max {
+			return int(v % uint64(n))
+		}
+	}
+}
+
+func (CryptoChooser) Shuffle(seed []byte, n int) []int {
+	// hashing lets any length of seed be an AES-256 key
+	key := sha256.Sum256(seed)
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		// can only happen with a bad key length, and sha256 is always 32
+		panic(err)
+	}
+
+	ks := keystream{stream: cipher.NewCTR(block, make([]byte, aes.BlockSize))}
+
+	// fisher-yates
+	order := make([]int, n)
+	for i := range order {
+		order[i] = i
+	}
+	for i := n - 1; i > 0; i-- {
+		j := ks.intn(i + 1)
+		order[i], order[j] = order[j], order[i]
+	}
+
+	return order
+}
+
+// SeededChooser is a predictable Chooser for tests and reproducing bugs.
+// The same starting seed gives the same runs in the same order.
+type SeededChooser struct {
+	lock sync.Mutex
+	rng  *pseudoRand.Rand
+}
+
+func NewSeededChooser(seed int64) *SeededChooser {
+	return &SeededChooser{rng: pseudoRand.New(pseudoRand.NewSource(seed))}
+}
+
+func (c *SeededChooser) NewSeed() ([]byte, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	seed := make([]byte, 8)
+	binary.BigEndian.PutUint64(seed, c.rng.Uint64())
+	return seed, nil
+}
+
+func (c *SeededChooser) Shuffle(seed []byte, n int) []int {
+	var s int64
+	for _, b := range seed {
+		s = s<<8 | int64(b)
+	}
+
+	return pseudoRand.New(pseudoRand.NewSource(s)).Perm(n)
+}
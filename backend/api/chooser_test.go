@@ -0,0 +1,128 @@
+package api
+
+import (
+	"backend/types"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// choosers is every Chooser, for the tests both have to pass.
+func choosers() map[string]Chooser {
+	return map[string]Chooser{
+		"crypto": CryptoChooser{},
+		"seeded": NewSeededChooser(42),
+	}
+}
+
+func TestShufflePermutation(t *testing.T) {
+	for name, chooser := range choosers() {
+		for _, n := range []int{0, 1, 2, 7, 100, 1000} {
+			seed, err := chooser.NewSeed()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			order := chooser.Shuffle(seed, n)
+			if len(order) != n {
+				t.Fatalf("%s: shuffle of %d has %d entries", name, n, len(order))
+			}
+
+			seen := make([]bool, n)
+			for _, i := range order {
+				if i < 0 || i >= n || seen[i] {
+					t.Fatalf("%s: shuffle of %d isn't a permutation: %v", name, n, order)
+				}
+				seen[i] = true
+			}
+		}
+	}
+}
+
+func TestShuffleSameSeed(t *testing.T) {
+	for name, chooser := range choosers() {
+		seed, err := chooser.NewSeed()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		first := chooser.Shuffle(seed, 500)
+		if again := chooser.Shuffle(append([]byte{}, seed...), 500); !reflect.DeepEqual(first, again) {
+			t.Errorf("%s: the same seed gave a different order", name)
+		}
+
+		other, err := chooser.NewSeed()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if reflect.DeepEqual(first, chooser.Shuffle(other, 500)) {
+			t.Errorf("%s: a different seed gave the same order", name)
+		}
+	}
+}
+
+func TestNewSeed(t *testing.T) {
+	chooser := CryptoChooser{}
+
+	a, err := chooser.NewSeed()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := chooser.NewSeed()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(a) != SEED_LENGTH || reflect.DeepEqual(a, b) {
+		t.Errorf("seeds should be %d random bytes, got %x and %x", SEED_LENGTH, a, b)
+	}
+}
+
+// runClips is the clips of the next run q would start, in order.
+func runClips(t *testing.T, q *QuizAPI, manifest types.RandomManifest) []string {
+	t.Helper()
+
+	seed, err := q.chooser.NewSeed()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var clips []string
+	for position := 0; ; position++ {
+		clip, _, ok := q.clipAt(manifest, seed, position)
+		if !ok {
+			return clips
+		}
+		clips = append(clips, clip)
+	}
+}
+
+func TestSeededRunsReproduce(t *testing.T) {
+	manifest := types.RandomManifest{Lookup: map[string]types.Episode{}}
+	for i := 0; i < 50; i++ {
+		clip := fmt.Sprintf("clip%d", i)
+		manifest.Keys = append(manifest.Keys, clip)
+		manifest.Lookup[clip] = "ep"
+	}
+
+	// two servers started with the same seed play the same runs
+	a := &QuizAPI{chooser: NewSeededChooser(7)}
+	b := &QuizAPI{chooser: NewSeededChooser(7)}
+
+	var runs [][]string
+	for i := 0; i < 3; i++ {
+		run := runClips(t, a, manifest)
+		if again := runClips(t, b, manifest); !reflect.DeepEqual(run, again) {
+			t.Fatalf("run %d went %v one time and %v the next", i, run, again)
+		}
+		if len(run) != len(manifest.Keys) {
+			t.Fatalf("run %d only had %d of %d clips", i, len(run), len(manifest.Keys))
+		}
+		runs = append(runs, run)
+	}
+
+	if reflect.DeepEqual(runs[0], runs[1]) {
+		t.Error("two runs from the one chooser heard the clips in the same order")
+	}
+}
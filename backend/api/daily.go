@@ -0,0 +1,222 @@
+package api
+
+import (
+	"backend/ratelimit"
+	"backend/storage"
+	"backend/types"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// everyone gets the same clips in the same order on a given day
+func (q *QuizAPI) dailySeed(day string, difficulty types.Difficulty) []byte {
+	mac := hmac.New(sha256.New, q.dailySecret)
+	mac.Write([]byte(fmt.Sprintf("daily|%s|%s", day, difficulty)))
+	return mac.Sum(nil)
+}
+
+const PLAYER_ID_LENGTH = 16
+
+// playerSignature is what stops people from making up player tokens. It's
+// signed with the daily secret rather than a rotating key so tokens last as
+// long as the player keeps them.
+func (q *QuizAPI) playerSignature(id string) string {
+	mac := hmac.New(sha256.New, q.dailySecret)
+	mac.Write([]byte("player|" + id))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// playerToken is who they are for the one ranked daily attempt rule. There
+// are no accounts, so it's the `<id>.<sig>` token from the Player-Token
+// header, or a new one if they don't have one yet. Clients should keep it
+// and send it with every daily run. Dropping it only gets them a new token,
+// startDaily goes by their address as well.
+func (q *QuizAPI) playerToken(req *http.Request) (string, error) {
+	token := req.Header.Get("Player-Token")
+	if parts := strings.Split(token, "."); len(parts) == 2 && len(parts[0]) == 2*PLAYER_ID_LENGTH {
+		if hmac.Equal([]byte(parts[1]), []byte(q.playerSignature(parts[0]))) {
+			return token, nil
+		}
+		log.Printf("bad player token signature, handing out a new one")
+	}
+
+	bytes := make([]byte, PLAYER_ID_LENGTH)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", fmt.Errorf("failed to make player id: %w", err)
+	}
+
+	id := hex.EncodeToString(bytes)
+	return id + "." + q.playerSignature(id), nil
+}
+
+// startDaily sets up claims for a daily challenge run and returns the player
+// token it was counted against. Only the first attempt per player, and per
+// address, per day is ranked, later ones can play but not register a score.
+func (q *QuizAPI) startDaily(req *http.Request, claims *TokenClaims) (string, error) {
+	player, err := q.playerToken(req)
+	if err != nil {
+		return "", err
+	}
+
+	claims.Mode = types.Daily
+	claims.Day = types.Today()
+	claims.Seed = q.dailySeed(claims.Day, claims.Difficulty)
+
+	// long enough to outlast the day
+	expires := time.Now().Add(48 * time.Hour)
+	attempts := []string{
+		fmt.Sprintf("%s|%s|player|%s", claims.Day, claims.Difficulty, strings.SplitN(player, ".", 2)[0]),
+		fmt.Sprintf("%s|%s|address|%s", claims.Day, claims.Difficulty, ratelimit.ClientIP(req)),
+	}
+
+	// burn both even if the first was already, so neither is left for later
+	played := false
+	for _, attempt := range attempts {
+		burned, err := q.burned.Burn(storage.BurnedDailyAttempt, attempt, expires)
+		if err != nil {
+			return "", fmt.Errorf("failed to record daily attempt: %w", err)
+		}
+		played = played || burned
+	}
+
+	claims.Ranked = !played
+	return player, nil
+}
+
+// parseDailyParams gets a past day and difficulty out of the query, days that
+// are still being played are refused.
+func parseDailyParams(query url.Values) (string, types.Difficulty, error) {
+	day := query.Get("day")
+	if _, err := time.Parse("2006-01-02", day); err != nil {
+		return "", "", fmt.Errorf("bad day '%s'", day)
+	}
+
+	if day >= types.Today() {
+		return "", "", fmt.Errorf("%s isn't over yet", day)
+	}
+
+	difficulty := types.Difficulty(query.Get("difficulty"))
+	if difficulty != types.Easy && difficulty != types.Medium && difficulty != types.Hard && difficulty != types.Legend {
+		return "", "", fmt.Errorf("bad difficulty '%s'", difficulty)
+	}
+
+	return day, difficulty, nil
+}
+
+type recapClip struct {
+	Position int           `json:"position"`
+	Episode  types.Episode `json:"episode"`
+	Name     string        `json:"name"`
+	Url      string        `json:"url"`
+}
+
+type dailyRecap struct {
+	Day        string              `json:"day"`
+	Difficulty types.Difficulty    `json:"difficulty"`
+	HighScores []storage.HighScore `json:"highscores"`
+	Clips      []recapClip         `json:"clips"`
+}
+
+// DailyRecapEndpoint shows a finished day's leaderboard and every clip in
+// that day's sequence with its answer. The clips are from the current
+// manifests, so a reload since then can change them.
+func (q *QuizAPI) DailyRecapEndpoint(w http.ResponseWriter, req *http.Request) {
+	if q.dailySecret == nil {
+		http.NotFound(w, req)
+		return
+	}
+
+	day, difficulty, err := parseDailyParams(req.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	boards, err := q.dataStore.DailyHighScores(day)
+	if err != nil {
+		log.Printf("failed to get daily high scores: %s", err)
+		http.Error(w, "failed to get high scores!", http.StatusInternalServerError)
+		return
+	}
+
+	current := q.manifests.Current()
+	manifest := current.Manifests[difficulty]
+	seed := q.dailySeed(day, difficulty)
+
+	recap := dailyRecap{
+		Day:        day,
+		Difficulty: difficulty,
+		HighScores: boards[string(difficulty)],
+		Clips:      make([]recapClip, 0, len(manifest.Keys)),
+	}
+
+	for position := 0; ; position++ {
+		_, episode, ok := q.clipAt(manifest, seed, position)
+		if !ok {
+			break
+		}
+
+		clip := recapClip{
+			Position: position,
+			Episode:  episode,
+			Url:      fmt.Sprintf("/clipquiz/v1/daily/clip?day=%s&difficulty=%s&position=%d", day, difficulty, position),
+		}
+		if category, ok := current.Categories.Get(episode); ok {
+			clip.Name = category.Name
+		}
+
+		recap.Clips = append(recap.Clips, clip)
+	}
+
+	bytes, err := json.Marshal(&recap)
+	if err != nil {
+		log.Printf("failed to marshall daily recap: %s", err)
+		http.Error(w, "failed to marshall daily recap!", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Add("Expires", time.Now().Add(time.Minute).Format(http.TimeFormat))
+	w.Write(bytes)
+}
+
+// DailyClipEndpoint serves a clip from a finished day's sequence, for the
+// recap.
+func (q *QuizAPI) DailyClipEndpoint(w http.ResponseWriter, req *http.Request) {
+	if q.dailySecret == nil {
+		http.NotFound(w, req)
+		return
+	}
+
+	query := req.URL.Query()
+	day, difficulty, err := parseDailyParams(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	position, err := strconv.Atoi(query.Get("position"))
+	if err != nil || position < 0 {
+		http.Error(w, "bad position", http.StatusBadRequest)
+		return
+	}
+
+	fileName, _, ok := q.clipAt(q.manifests.Current().Manifests[difficulty], q.dailySeed(day, difficulty), position)
+	if !ok {
+		http.NotFound(w, req)
+		return
+	}
+
+	q.serveClip(w, req, fileName)
+}
@@ -0,0 +1,73 @@
+package api
+
+import (
+	"backend/storage"
+	"backend/types"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestStartDailyRanksOneAttempt(t *testing.T) {
+	q := &QuizAPI{burned: storage.NewMemoryBurnRegistry(), dailySecret: []byte("secret")}
+
+	start := func(token, address string) (string, bool) {
+		req := httptest.NewRequest("POST", "/clipquiz/v1/clip?mode=daily", nil)
+		req.RemoteAddr = address + ":1234"
+		if token != "" {
+			req.Header.Set("Player-Token", token)
+		}
+
+		claims := TokenClaims{Difficulty: types.Easy}
+		player, err := q.startDaily(req, &claims)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return player, claims.Ranked
+	}
+
+	alice, ranked := start("", "203.0.113.1")
+	if !ranked {
+		t.Fatal("the first attempt wasn't ranked")
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		address string
+	}{
+		{"same player", alice, "203.0.113.1"},
+		{"same player somewhere else", alice, "198.51.100.7"},
+		{"no token", "", "203.0.113.1"},
+		{"forged token", "00000000000000000000000000000000.c2lnbmF0dXJl", "203.0.113.1"},
+	}
+
+	for _, test := range tests {
+		if player, ranked := start(test.token, test.address); ranked {
+			t.Errorf("%s: another ranked attempt as %s", test.name, player)
+		}
+	}
+
+	if _, ranked := start("", "192.0.2.50"); !ranked {
+		t.Error("a new player somewhere new wasn't ranked")
+	}
+}
+
+func TestDayOver(t *testing.T) {
+	yesterday := time.Now().UTC().AddDate(0, 0, -1).Format("2006-01-02")
+
+	tests := []struct {
+		claims TokenClaims
+		want   bool
+	}{
+		{TokenClaims{Mode: types.Daily, Day: types.Today()}, false},
+		{TokenClaims{Mode: types.Daily, Day: yesterday}, true},
+		{TokenClaims{Mode: types.Classic}, false},
+	}
+
+	for _, test := range tests {
+		if got := dayOver(test.claims); got != test.want {
+			t.Errorf("dayOver for a %s run on %q = %t, want %t", test.claims.Mode, test.claims.Day, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,251 @@
+package api
+
+import (
+	"backend/ratelimit"
+	"backend/storage"
+	"backend/types"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Integrity is when a finished run is too suspicious to go straight on the
+// boards. Flagged scores are kept as pending until an admin looks at them.
+type Integrity struct {
+	Enabled bool
+
+	// flag when at least FastShare of the answers, and at least MinAnswers
+	// of them, came quicker than FastAnswer
+	FastAnswer time.Duration
+	FastShare  float64
+	MinAnswers int
+
+	// flag a perfect legend run that an honest player would manage less
+	// often than LegendOdds, going by how everyone does on its clips
+	LegendOdds float64
+
+	// flag when their address started more than HourlyRuns runs in the last
+	// hour
+	HourlyRuns int
+}
+
+// DefaultIntegrity is what INTEGRITY starts from.
+var DefaultIntegrity = Integrity{
+	Enabled:    true,
+	FastAnswer: time.Millisecond * 800,
+	FastShare:  0.8,
+	MinAnswers: 5,
+	LegendOdds: 0.001,
+	HourlyRuns: 60,
+}
+
+// CLIP_RATES_INTERVAL is how stale the correct rates perfectRunOdds goes by
+// can get, adding up every guess ever made isn't something to do per score.
+const CLIP_RATES_INTERVAL = 10 * time.Minute
+
+const (
+	FlagFastAnswers      = "fast-answers"
+	FlagImprobableLegend = "improbable-legend"
+	FlagBusyAddress      = "busy-address"
+)
+
+// ParseIntegrity reads `on`, or a list like `fast=1s,share=0.9,odds=0.0001`
+// where anything left out comes from DefaultIntegrity. Empty is off.
+func ParseIntegrity(value string) (Integrity, error) {
+	if value == "" {
+		return Integrity{}, nil
+	}
+
+	integrity := DefaultIntegrity
+	if value == "on" {
+		return integrity, nil
+	}
+
+	for _, pair := range strings.Split(value, ",") {
+		parts := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(parts) != 2 {
+			return Integrity{}, fmt.Errorf("expected name=value, got '%s'", pair)
+		}
+
+		var err error
+		switch parts[0] {
+		case "fast":
+			integrity.FastAnswer, err = time.ParseDuration(parts[1])
+		case "share":
+			integrity.FastShare, err = strconv.ParseFloat(parts[1], 64)
+		case "min":
+			integrity.MinAnswers, err = strconv.Atoi(parts[1])
+		case "odds":
+			integrity.LegendOdds, err = strconv.ParseFloat(parts[1], 64)
+		case "runs":
+			integrity.HourlyRuns, err = strconv.Atoi(parts[1])
+		default:
+			return Integrity{}, fmt.Errorf("unknown setting '%s'", parts[0])
+		}
+
+		if err != nil {
+			return Integrity{}, fmt.Errorf("bad %s: %w", parts[0], err)
+		}
+	}
+
+	return integrity, nil
+}
+
+func (i Integrity) String() string {
+	if !i.Enabled {
+		return "off"
+	}
+	return fmt.Sprintf("fast=%s,share=%g,min=%d,odds=%g,runs=%d", i.FastAnswer, i.FastShare, i.MinAnswers, i.LegendOdds, i.HourlyRuns)
+}
+
+// recordStart counts a run starting for the proof of work and integrity
+// checks.
+func (q *QuizAPI) recordStart(req *http.Request) {
+	client := ratelimit.ClientIP(req)
+	now := time.Now()
+
+	q.recentStarts.record(client, now)
+	q.hourlyStarts.record(client, now)
+}
+
+// integrityFlags is why the finished run in claims, being registered by req,
+// looks suspicious. Nothing if it doesn't.
+func (q *QuizAPI) integrityFlags(req *http.Request, claims TokenClaims) []string {
+	if !q.integrity.Enabled {
+		return nil
+	}
+
+	flags := []string{}
+
+	if claims.Answered >= q.integrity.MinAnswers && float64(claims.Fast) >= q.integrity.FastShare*float64(claims.Answered) {
+		flags = append(flags, FlagFastAnswers)
+	}
+
+	if claims.Difficulty == types.Legend && perfectRun(claims) {
+		odds, err := q.perfectRunOdds(claims.Id)
+		if err != nil {
+			log.Printf("failed to work out odds for %s: %s", claims.Id, err)
+		} else if odds < q.integrity.LegendOdds {
+			flags = append(flags, FlagImprobableLegend)
+		}
+	}
+
+	if clientRuns, _ := q.hourlyStarts.rates(ratelimit.ClientIP(req), time.Now()); clientRuns > float64(q.integrity.HourlyRuns) {
+		flags = append(flags, FlagBusyAddress)
+	}
+
+	return flags
+}
+
+// perfectRun is whether they got everything right, apart from the wrong
+// answer that ends a sudden death run.
+func perfectRun(claims TokenClaims) bool {
+	if claims.CurrentScore == 0 {
+		return false
+	}
+
+	switch claims.Mode {
+	case types.Classic, types.Daily:
+		return claims.Wrong <= 1
+	default:
+		return claims.Wrong == 0
+	}
+}
+
+// clipTally is how many guesses a clip has had, and how many were right.
+type clipTally struct {
+	guessed, correct int
+}
+
+// clipRates keeps everyone's guesses on each clip from the last
+// ClipStats, fetched again once it's older than CLIP_RATES_INTERVAL.
+type clipRates struct {
+	lock sync.Mutex
+
+	taken   time.Time // when the query started
+	tallies map[string]clipTally
+}
+
+// get returns the tallies and when they were taken.
+func (c *clipRates) get(history storage.HistoryStore) (map[string]clipTally, time.Time, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.tallies != nil && time.Since(c.taken) <= CLIP_RATES_INTERVAL {
+		return c.tallies, c.taken, nil
+	}
+
+	taken := time.Now()
+	stats, err := history.ClipStats()
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	tallies := make(map[string]clipTally, len(stats))
+	for _, stat := range stats {
+		tally := tallies[stat.Clip]
+		tally.guessed += stat.Guessed
+		tally.correct += stat.Correct
+		tallies[stat.Clip] = tally
+	}
+
+	c.tallies, c.taken = tallies, taken
+	return tallies, taken, nil
+}
+
+// perfectRunOdds is the chance of getting every clip in the run right, going
+// by everyone else's correct rate on each one. The rates are smoothed so
+// clips few people have heard don't count as certain either way.
+func (q *QuizAPI) perfectRunOdds(id string) (float64, error) {
+	run, err := q.history.GetRun(id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get run: %w", err)
+	}
+
+	tallies, taken, err := q.clipRates.get(q.history)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get clip stats: %w", err)
+	}
+
+	// take out the guesses of this run that made it into the tallies, a
+	// run shouldn't vouch for itself
+	others := make(map[string]clipTally, len(run.Clips))
+	for _, clip := range run.Clips {
+		tally, ok := others[clip.Clip]
+		if !ok {
+			tally = tallies[clip.Clip]
+		}
+
+		if clip.Guessed != nil && clip.Guessed.Before(taken) && tally.guessed > 0 {
+			tally.guessed--
+			if clip.Correct != nil && *clip.Correct && tally.correct > 0 {
+				tally.correct--
+			}
+		}
+		others[clip.Clip] = tally
+	}
+
+	odds := 1.0
+	for _, clip := range run.Clips {
+		if clip.Correct == nil || !*clip.Correct {
+			continue
+		}
+
+		tally := others[clip.Clip]
+		odds *= float64(tally.correct+1) / float64(tally.guessed+2)
+	}
+
+	return odds, nil
+}
+
+// scoreStatus is whether a score with flags goes on the boards.
+func scoreStatus(flags []string) storage.ScoreStatus {
+	if len(flags) > 0 {
+		return storage.StatusPending
+	}
+	return storage.StatusAccepted
+}
@@ -0,0 +1,94 @@
+package api
+
+import (
+	"backend/storage"
+	"fmt"
+	"math"
+	"testing"
+	"time"
+)
+
+// playClip records a run that gets one guess at clip.
+func playClip(t *testing.T, history storage.HistoryStore, id, clip string, correct bool) {
+	t.Helper()
+
+	if err := history.StartRun(id, "legend"); err != nil {
+		t.Fatal(err)
+	}
+	if err := history.RecordClip(id, clip, "ep1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := history.RecordGuess(id, "ep1", correct, 1); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPerfectRunOdds(t *testing.T) {
+	history := storage.NewMemoryHistoryStore()
+	q := &QuizAPI{history: history}
+
+	// everyone else gets clip a right half the time, nobody has had clip b
+	for i := 0; i < 10; i++ {
+		playClip(t, history, fmt.Sprintf("other-%d", i), "a", i%2 == 0)
+	}
+	playClip(t, history, "run", "a", true)
+	if err := history.RecordClip("run", "b", "ep1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := history.RecordGuess("run", "ep1", true, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		setup func()
+		want  float64
+	}{
+		// (5+1)/(10+2) for a, (0+1)/(0+2) for b
+		{"the run's own guesses don't count", func() {}, 0.25},
+		{"cached until the interval is up", func() {
+			for i := 0; i < 10; i++ {
+				playClip(t, history, fmt.Sprintf("late-%d", i), "a", true)
+			}
+		}, 0.25},
+		// (15+1)/(20+2)
+		{"refreshed after the interval", func() {
+			q.clipRates.taken = q.clipRates.taken.Add(-CLIP_RATES_INTERVAL - time.Second)
+		}, 16.0 / 22 * 0.5},
+	}
+
+	for _, test := range tests {
+		test.setup()
+
+		odds, err := q.perfectRunOdds("run")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if math.Abs(odds-test.want) > 1e-9 {
+			t.Errorf("%s: odds = %g, want %g", test.name, odds, test.want)
+		}
+	}
+}
+
+func TestPerfectRunOddsBeforeItsGuesses(t *testing.T) {
+	history := storage.NewMemoryHistoryStore()
+	q := &QuizAPI{history: history}
+
+	for i := 0; i < 4; i++ {
+		playClip(t, history, fmt.Sprintf("other-%d", i), "a", false)
+	}
+
+	// taken before the run was played, so there's nothing of it to take out
+	if _, _, err := q.clipRates.get(history); err != nil {
+		t.Fatal(err)
+	}
+	playClip(t, history, "run", "a", true)
+
+	odds, err := q.perfectRunOdds("run")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := 1.0 / 6; math.Abs(odds-want) > 1e-9 {
+		t.Errorf("odds = %g, want %g", odds, want)
+	}
+}
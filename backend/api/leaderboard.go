@@ -0,0 +1,303 @@
+package api
+
+import (
+	"backend/storage"
+	"backend/types"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// the biggest int, math.MaxInt is newer than the go this builds with
+const maxInt = int(^uint(0) >> 1)
+
+const (
+	DEFAULT_PAGE_SIZE = 10
+	// the most scores either side of one that a rank lookup hands back
+	MAX_AROUND = 25
+)
+
+// parseBoard gets a board out of the query: a mode (classic if it's left
+// out), a difficulty, and a window, or a day for daily boards.
+func parseBoard(query url.Values) (storage.Board, error) {
+	board := storage.Board{
+		Mode:       types.Mode(query.Get("mode")),
+		Difficulty: types.Difficulty(query.Get("difficulty")),
+	}
+
+	if board.Mode == "" {
+		board.Mode = types.Classic
+	}
+
+	switch board.Mode {
+	case types.Classic, types.Lives, types.Round, types.Blitz, types.Daily:
+	default:
+		return storage.Board{}, fmt.Errorf("bad mode '%s'", board.Mode)
+	}
+
+	switch board.Difficulty {
+	case types.Easy, types.Medium, types.Hard, types.Legend:
+	default:
+		return storage.Board{}, fmt.Errorf("bad difficulty '%s'", board.Difficulty)
+	}
+
+	if board.Mode == types.Daily {
+		board.Day = query.Get("day")
+		if board.Day == "" {
+			board.Day = types.Today()
+		} else if _, err := time.Parse("2006-01-02", board.Day); err != nil {
+			return storage.Board{}, fmt.Errorf("bad day '%s'", board.Day)
+		}
+		return board, nil
+	}
+
+	window, err := parseWindow(query)
+	if err != nil {
+		return storage.Board{}, err
+	}
+	board.Window = window
+
+	return board, nil
+}
+
+// parseWindow is the window in the query, all time if it's left out.
+func parseWindow(query url.Values) (storage.Window, error) {
+	window := storage.Window(query.Get("window"))
+	if window == "" {
+		return storage.WindowAllTime, nil
+	}
+
+	for _, known := range storage.Windows {
+		if window == known {
+			return window, nil
+		}
+	}
+
+	return "", fmt.Errorf("bad window '%s'", window)
+}
+
+// queryInt is the number in the query under key, or fallback if it isn't
+// there.
+func queryInt(query url.Values, key string, fallback int) (int, error) {
+	value := query.Get(key)
+	if value == "" {
+		return fallback, nil
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("bad %s '%s'", key, value)
+	}
+
+	return n, nil
+}
+
+type boardPage struct {
+	Mode       types.Mode       `json:"mode"`
+	Difficulty types.Difficulty `json:"difficulty"`
+	Window     storage.Window   `json:"window,omitempty"`
+	Day        string           `json:"day,omitempty"`
+
+	Page   int                   `json:"page"`
+	Size   int                   `json:"size"`
+	Total  int                   `json:"total"` // scores on the whole board
+	Scores []storage.RankedScore `json:"scores"`
+}
+
+// LeaderboardEndpoint is one page of a board, see parseBoard. Pages start at
+// 1 and have up to `size` scores on them.
+func (q *QuizAPI) LeaderboardEndpoint(w http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+
+	board, err := parseBoard(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	page, err := queryInt(query, "page", 1)
+	if err == nil && page < 1 {
+		err = fmt.Errorf("pages start at 1")
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	size, err := queryInt(query, "size", DEFAULT_PAGE_SIZE)
+	if err == nil && (size < 1 || size > storage.MAX_PAGE_SIZE) {
+		err = fmt.Errorf("size must be between 1 and %d", storage.MAX_PAGE_SIZE)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// any further and the offset doesn't fit in an int
+	if page-1 > maxInt/size {
+		http.Error(w, fmt.Sprintf("page %d is past the end of any board", page), http.StatusBadRequest)
+		return
+	}
+
+	scores, total, err := q.dataStore.BoardPage(board, (page-1)*size, size)
+	if errors.Is(err, storage.ErrBadPage) {
+		http.Error(w, "bad page", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("failed to get board page: %s", err)
+		http.Error(w, "failed to get leaderboard!", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, boardPage{
+		Mode:       board.Mode,
+		Difficulty: board.Difficulty,
+		Window:     board.Window,
+		Day:        board.Day,
+		Page:       page,
+		Size:       size,
+		Total:      total,
+		Scores:     scores,
+	})
+}
+
+type rankDoc struct {
+	Id         string           `json:"id"`
+	Mode       types.Mode       `json:"mode"`
+	Difficulty types.Difficulty `json:"difficulty"`
+	Window     storage.Window   `json:"window,omitempty"`
+	Day        string           `json:"day,omitempty"`
+
+	Rank  int                 `json:"rank"`
+	Total int                 `json:"total"`
+	Entry storage.RankedScore `json:"entry"`
+
+	// the scores just ahead of it, best first, and just behind it
+	Above []storage.RankedScore `json:"above"`
+	Below []storage.RankedScore `json:"below"`
+}
+
+// RankEndpoint is where the score with `id` is on its board in `window`,
+// with up to `around` scores either side of it.
+func (q *QuizAPI) RankEndpoint(w http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+
+	window, err := parseWindow(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	around, err := queryInt(query, "around", 0)
+	if err == nil && (around < 0 || around > MAX_AROUND) {
+		err = fmt.Errorf("around must be between 0 and %d", MAX_AROUND)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	id := query.Get("id")
+	records, err := q.dataStore.ListScores(storage.ScoreFilter{Id: id, Status: storage.StatusAccepted})
+	if err != nil {
+		log.Printf("failed to get score: %s", err)
+		http.Error(w, "failed to get score!", http.StatusInternalServerError)
+		return
+	}
+
+	if id == "" || len(records) == 0 {
+		http.Error(w, "no score with that id", http.StatusNotFound)
+		return
+	}
+
+	board := boardFor(records[0].Mode, records[0].Difficulty, records[0].Day, window)
+
+	rank, err := q.dataStore.Rank(board, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "that score isn't on that board", http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Printf("failed to rank score: %s", err)
+		http.Error(w, "failed to rank score!", http.StatusInternalServerError)
+		return
+	}
+
+	offset := rank - 1 - around
+	if offset < 0 {
+		offset = 0
+	}
+
+	scores, total, err := q.dataStore.BoardPage(board, offset, rank-offset+around)
+	if err != nil {
+		log.Printf("failed to get scores around rank: %s", err)
+		http.Error(w, "failed to rank score!", http.StatusInternalServerError)
+		return
+	}
+
+	doc := rankDoc{
+		Id:         id,
+		Mode:       board.Mode,
+		Difficulty: board.Difficulty,
+		Window:     board.Window,
+		Day:        board.Day,
+		Rank:       rank,
+		Total:      total,
+		Above:      []storage.RankedScore{},
+		Below:      []storage.RankedScore{},
+	}
+
+	for _, score := range scores {
+		switch {
+		case score.Rank < rank:
+			doc.Above = append(doc.Above, score)
+		case score.Rank == rank:
+			doc.Entry = score
+		default:
+			doc.Below = append(doc.Below, score)
+		}
+	}
+
+	writeJSON(w, http.StatusOK, doc)
+}
+
+// boardFor is the board a score goes on in window, daily scores only have
+// the one board for their day.
+func boardFor(mode types.Mode, difficulty types.Difficulty, day string, window storage.Window) storage.Board {
+	if mode == types.Daily {
+		return storage.Board{Mode: mode, Difficulty: difficulty, Day: day}
+	}
+	return storage.Board{Mode: mode, Difficulty: difficulty, Window: window}
+}
+
+// ranks is where a score that was just registered is on each of its boards,
+// keyed by window, or "daily" for daily runs.
+func (q *QuizAPI) ranks(entry storage.ScoreEntry) map[string]int {
+	ranks := make(map[string]int)
+
+	windows := storage.Windows
+	if entry.Mode == types.Daily {
+		windows = []storage.Window{""}
+	}
+
+	for _, window := range windows {
+		rank, err := q.dataStore.Rank(boardFor(entry.Mode, entry.Difficulty, entry.Day, window), entry.Id)
+		if err != nil {
+			log.Printf("failed to rank new score %s: %s", entry.Id, err)
+			continue
+		}
+
+		if window == "" {
+			ranks[string(types.Daily)] = rank
+		} else {
+			ranks[string(window)] = rank
+		}
+	}
+
+	return ranks
+}
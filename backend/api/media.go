@@ -0,0 +1,228 @@
+package api
+
+import (
+	"backend/cryptopasta"
+	"backend/keys"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// ClipLinks is how clips are handed out by url instead of inline. Links are
+// `<kid>.<expires>.<ref>.<sig>`, where ref is the clip's file name encrypted
+// with a fresh nonce, so the same clip never gets the same link twice and a
+// link doesn't say which clip it is.
+type ClipLinks struct {
+	// put in front of the link's path, e.g. a CDN that passes through to us.
+	// Empty means links are relative to this server.
+	Base string
+	// if set, the media endpoint redirects here instead of serving the file
+	// itself, to `<Redirect>/<token>` where the token is sealed afresh every
+	// time, see redirectLink. Whatever answers there has to open it with
+	// RedirectObject and the same RedirectSecret, then serve that object from
+	// a store holding each clip's .enc file under its RedirectKey, which
+	// `backend redirect-layout` lays a clip directory out as. No two
+	// redirects go to the same url, so a shared cache in front of it never
+	// gets a hit.
+	Redirect       string
+	RedirectSecret []byte
+	Lifetime       time.Duration
+}
+
+// RedirectKey is where the clip fileName lives under ClipLinks.Redirect, an
+// HMAC of its name so the url doesn't give the answer away. The secret has to
+// stay the same for as long as the store is laid out with it.
+func RedirectKey(secret []byte, fileName string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte("redirect|" + fileName))
+	return hex.EncodeToString(mac.Sum(nil)[:16]) + ".enc"
+}
+
+// redirectSealKey is what redirect tokens are sealed with, kept apart from
+// the key names so one can't be worked out from the other.
+func redirectSealKey(secret []byte) *[32]byte {
+	key := sha256.Sum256(append([]byte("redirect-seal|"), secret...))
+	return &key
+}
+
+// redirectLink is where to send them for fileName until expires. The object
+// key and expiry are sealed with a fresh nonce, so the url is different on
+// every serve and doesn't say which clip it is.
+func (l ClipLinks) redirectLink(fileName string, expires time.Time) (string, error) {
+	sealed, err := cryptopasta.Encrypt([]byte(fmt.Sprintf("%d|%s", expires.Unix(), RedirectKey(l.RedirectSecret, fileName))), redirectSealKey(l.RedirectSecret))
+	if err != nil {
+		return "", fmt.Errorf("failed to seal redirect: %w", err)
+	}
+
+	return l.Redirect + "/" + base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+// RedirectObject opens a redirect token and returns the RedirectKey of the
+// clip it's for, as long as it hasn't expired. It's for whatever serves
+// ClipLinks.Redirect.
+func RedirectObject(secret []byte, token string) (string, error) {
+	sealed, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return "", fmt.Errorf("base64 decoding error %w", err)
+	}
+
+	opened, err := cryptopasta.Decrypt(sealed, redirectSealKey(secret))
+	if err != nil {
+		return "", fmt.Errorf("failed to open redirect: %w", err)
+	}
+
+	parts := strings.SplitN(string(opened), "|", 2)
+	if len(parts) != 2 {
+		return "", fmt.Errorf("malformed redirect")
+	}
+
+	expiresUnix, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("bad expiry: %w", err)
+	}
+
+	if expires := time.Unix(expiresUnix, 0); time.Now().After(expires) {
+		return "", fmt.Errorf("redirect expired at %s", expires)
+	}
+
+	return parts[1], nil
+}
+
+const MEDIA_PATH = "/clipquiz/v1/media/"
+
+func mediaSignature(key *keys.Key, payload string) string {
+	mac := hmac.New(sha256.New, key.SignatureKey)
+	mac.Write([]byte("media|" + payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// clipLink is a signed url for fileName that works until the link lifetime
+// is up.
+func (q *QuizAPI) clipLink(fileName string) (string, error) {
+	key := q.keyRing.Current()
+
+	encrypted, err := cryptopasta.Encrypt([]byte(fileName), &key.EncryptionKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to encrypt clip name: %w", err)
+	}
+
+	expires := time.Now().Add(q.links.Lifetime).Unix()
+	payload := fmt.Sprintf("%s.%d.%s", key.Id, expires, base64.RawURLEncoding.EncodeToString(encrypted))
+
+	return q.links.Base + MEDIA_PATH + payload + "." + mediaSignature(&key, payload), nil
+}
+
+// clipFromLink checks a link's signature and expiry and returns the file
+// name in it, and when it expires.
+func (q *QuizAPI) clipFromLink(link string) (string, time.Time, error) {
+	parts := strings.Split(link, ".")
+	if len(parts) != 4 {
+		return "", time.Time{}, fmt.Errorf("malformed clip link")
+	}
+
+	key, ok := q.keyRing.Lookup(parts[0])
+	if !ok {
+		return "", time.Time{}, fmt.Errorf("unknown or expired key: %s", parts[0])
+	}
+
+	payload := strings.Join(parts[:3], ".")
+	if !hmac.Equal([]byte(parts[3]), []byte(mediaSignature(&key, payload))) {
+		return "", time.Time{}, fmt.Errorf("bad clip link signature")
+	}
+
+	expiresUnix, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("bad expiry: %w", err)
+	}
+
+	expires := time.Unix(expiresUnix, 0)
+	if time.Now().After(expires) {
+		return "", time.Time{}, fmt.Errorf("clip link expired at %s", expires)
+	}
+
+	encrypted, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("base64 decoding error %w", err)
+	}
+
+	fileName, err := cryptopasta.Decrypt(encrypted, &key.EncryptionKey)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to decrypt clip name: %w", err)
+	}
+
+	// it's signed, but don't let it wander out of the clip directory anyway
+	if strings.ContainsAny(string(fileName), `/\`) || strings.HasPrefix(string(fileName), ".") {
+		return "", time.Time{}, fmt.Errorf("bad clip name in link")
+	}
+
+	return string(fileName), expires, nil
+}
+
+// ClipMediaEndpoint serves the clip behind a signed link. Range requests and
+// If-None-Match work, and responses can be cached until the link expires.
+// With wrapping on every serve is wrapped afresh instead, with the key in
+// Clip-Key, and can't be cached.
+func (q *QuizAPI) ClipMediaEndpoint(w http.ResponseWriter, req *http.Request) {
+	link := mux.Vars(req)["link"]
+
+	fileName, expires, err := q.clipFromLink(link)
+	if err != nil {
+		log.Printf("bad clip link: %s", err)
+		http.NotFound(w, req)
+		return
+	}
+
+	if q.wrapClips {
+		serveWrapped(w, req, filepath.Join(q.clipDir, fileName)+".enc")
+		return
+	}
+
+	if q.links.Redirect != "" {
+		location, err := q.links.redirectLink(fileName, expires)
+		if err != nil {
+			log.Printf("failed to make redirect: %s", err)
+			http.Error(w, "failed to find clip", http.StatusInternalServerError)
+			return
+		}
+
+		http.Redirect(w, req, location, http.StatusFound)
+		return
+	}
+
+	file, err := os.Open(filepath.Join(q.clipDir, fileName) + ".enc")
+	if err != nil {
+		log.Printf("failed to open clip: %s", err)
+		http.NotFound(w, req)
+		return
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		log.Printf("failed to stat clip: %s", err)
+		http.Error(w, "failed to read clip", http.StatusInternalServerError)
+		return
+	}
+
+	// the etag is per link, one per clip would show when a clip comes round
+	// again. For the same reason there's no Last-Modified.
+	tag := sha256.Sum256([]byte(link + "|" + strconv.FormatInt(info.ModTime().UnixNano(), 10)))
+	w.Header().Set("ETag", `"`+hex.EncodeToString(tag[:16])+`"`)
+
+	maxAge := int(time.Until(expires) / time.Second)
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d, immutable", maxAge))
+	w.Header().Set("Content-Type", "application/octet-stream")
+
+	http.ServeContent(w, req, "", time.Time{}, file)
+}
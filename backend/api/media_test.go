@@ -0,0 +1,70 @@
+package api
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRedirectLink(t *testing.T) {
+	links := ClipLinks{Redirect: "https://clips.example.com", RedirectSecret: []byte("secret")}
+	expires := time.Now().Add(time.Minute)
+
+	token := func(location string) string {
+		if !strings.HasPrefix(location, links.Redirect+"/") {
+			t.Fatalf("redirect to %s isn't under %s", location, links.Redirect)
+		}
+		return strings.TrimPrefix(location, links.Redirect+"/")
+	}
+
+	first, err := links.redirectLink("clip1", expires)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := links.redirectLink("clip1", expires)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if first == second {
+		t.Error("the same clip was redirected to the same url twice")
+	}
+	if strings.Contains(first, "clip1") || strings.Contains(first, RedirectKey(links.RedirectSecret, "clip1")) {
+		t.Errorf("redirect %s gives the clip away", first)
+	}
+
+	for _, location := range []string{first, second} {
+		object, err := RedirectObject(links.RedirectSecret, token(location))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if object != RedirectKey(links.RedirectSecret, "clip1") {
+			t.Errorf("redirect opened to %s, want %s", object, RedirectKey(links.RedirectSecret, "clip1"))
+		}
+	}
+
+	expired, err := links.redirectLink("clip1", time.Now().Add(-time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tampered := []byte(token(first))
+	tampered[len(tampered)/2] ^= 1
+
+	tests := []struct {
+		name   string
+		secret string
+		token  string
+	}{
+		{"expired", "secret", token(expired)},
+		{"another secret", "other", token(first)},
+		{"tampered", "secret", string(tampered)},
+		{"garbage", "secret", "not-a-token"},
+	}
+
+	for _, test := range tests {
+		if object, err := RedirectObject([]byte(test.secret), test.token); err == nil {
+			t.Errorf("%s: opened to %s", test.name, object)
+		}
+	}
+}
@@ -0,0 +1,319 @@
+package api
+
+import (
+	"backend/names"
+	"backend/ratelimit"
+	"backend/storage"
+	"backend/types"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// how much of the audit log comes back without a limit
+const DEFAULT_AUDIT_LIMIT = 100
+
+// audit records something an admin did to the boards. The action has already
+// happened by now, so failing to record it is only logged.
+func (q *QuizAPI) audit(req *http.Request, action, target, detail string) {
+	err := q.moderation.Audit(storage.AuditEntry{
+		Created: time.Now(),
+		Actor:   ratelimit.ClientIP(req),
+		Action:  action,
+		Target:  target,
+		Detail:  detail,
+	})
+
+	if err != nil {
+		log.Printf("failed to audit %s of %s: %s", action, target, err)
+	}
+}
+
+// banned writes the rejection if the run or the name it's being registered
+// under has been banned from the boards.
+func (q *QuizAPI) banned(w http.ResponseWriter, runId, name string) bool {
+	ban, err := q.moderation.Banned(storage.BanSession, runId)
+	if err == nil && ban == nil {
+		ban, err = q.moderation.Banned(storage.BanName, name)
+	}
+
+	if err != nil {
+		log.Printf("failed to check bans: %s", err)
+		http.Error(w, "could not check bans", http.StatusInternalServerError)
+		return true
+	}
+
+	if ban == nil {
+		return false
+	}
+
+	log.Printf("run %s as '%s' is banned by %s '%s'", runId, name, ban.Kind, ban.Value)
+
+	// as far as they can tell it's just another name that isn't allowed
+	if ban.Kind == storage.BanName {
+		rejection := names.Rejection{Reason: names.ReasonBlocked}
+		writeJSON(w, http.StatusBadRequest, errorDoc{Error: rejection.Error(), Reason: string(rejection.Reason)})
+		return true
+	}
+
+	writeJSON(w, http.StatusForbidden, errorDoc{Error: "that run can't go on the boards", Reason: "banned"})
+	return true
+}
+
+// getScore loads the score with the id in the route, writing the error
+// response if it can't.
+func (q *QuizAPI) getScore(w http.ResponseWriter, req *http.Request) (storage.ScoreRecord, bool) {
+	id := mux.Vars(req)["id"]
+
+	records, err := q.dataStore.ListScores(storage.ScoreFilter{Id: id})
+	if err != nil {
+		log.Printf("failed to get score: %s", err)
+		http.Error(w, "failed to get score", http.StatusInternalServerError)
+		return storage.ScoreRecord{}, false
+	}
+
+	if len(records) == 0 {
+		http.Error(w, "no score with that id", http.StatusNotFound)
+		return storage.ScoreRecord{}, false
+	}
+
+	return records[0], true
+}
+
+// ListScoresEndpoint lists scores newest first, filtered by any of id,
+// status, mode, difficulty and name (anywhere in it, ignoring case).
+func (q *QuizAPI) ListScoresEndpoint(w http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+	limit, _ := strconv.Atoi(query.Get("limit"))
+
+	records, err := q.dataStore.ListScores(storage.ScoreFilter{
+		Id:         query.Get("id"),
+		Status:     storage.ScoreStatus(query.Get("status")),
+		Mode:       types.Mode(query.Get("mode")),
+		Difficulty: types.Difficulty(query.Get("difficulty")),
+		Name:       query.Get("name"),
+		Limit:      limit,
+	})
+
+	if err != nil {
+		log.Printf("failed to list scores: %s", err)
+		http.Error(w, "failed to list scores", http.StatusInternalServerError)
+		return
+	}
+
+	writeScoreRecords(w, records)
+}
+
+// DeleteScoreEndpoint removes a score for good. The audit log keeps a copy.
+func (q *QuizAPI) DeleteScoreEndpoint(w http.ResponseWriter, req *http.Request) {
+	record, ok := q.getScore(w, req)
+	if !ok {
+		return
+	}
+
+	if err := q.dataStore.DeleteScore(record.Id); errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "no score with that id", http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Printf("failed to delete score: %s", err)
+		http.Error(w, "failed to delete score", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("score %s deleted", record.Id)
+
+	snapshot, _ := json.Marshal(&record)
+	q.audit(req, "delete", record.Id, string(snapshot))
+
+	writeScoreRecords(w, &record)
+}
+
+// HideScoreEndpoint takes a score off the boards without deleting it, or puts
+// a hidden one back.
+func (q *QuizAPI) HideScoreEndpoint(hide bool) http.HandlerFunc {
+	action, status := "unhide", storage.StatusAccepted
+	if hide {
+		action, status = "hide", storage.StatusHidden
+	}
+
+	return func(w http.ResponseWriter, req *http.Request) {
+		record, ok := q.getScore(w, req)
+		if !ok {
+			return
+		}
+
+		if hide == (record.Status == storage.StatusHidden) {
+			http.Error(w, fmt.Sprintf("can't %s a score that's %s", action, record.Status), http.StatusConflict)
+			return
+		}
+
+		if err := q.dataStore.SetStatus(record.Id, status); err != nil {
+			log.Printf("failed to %s score: %s", action, err)
+			http.Error(w, "failed to change score", http.StatusInternalServerError)
+			return
+		}
+
+		log.Printf("score %s: %s", record.Id, action)
+		q.audit(req, action, record.Id, fmt.Sprintf("was %s", record.Status))
+
+		record.Status = status
+		writeScoreRecords(w, &record)
+	}
+}
+
+// RenameScoreEndpoint changes the name on a score to `?name=`, which still has
+// to pass the name policy.
+func (q *QuizAPI) RenameScoreEndpoint(w http.ResponseWriter, req *http.Request) {
+	record, ok := q.getScore(w, req)
+	if !ok {
+		return
+	}
+
+	name, ok := q.checkName(w, req.URL.Query().Get("name"))
+	if !ok {
+		return
+	}
+
+	if err := q.dataStore.RenameScore(record.Id, name); err != nil {
+		log.Printf("failed to rename score: %s", err)
+		http.Error(w, "failed to rename score", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("score %s renamed", record.Id)
+	q.audit(req, "rename", record.Id, fmt.Sprintf("'%s' to '%s'", record.Name, name))
+
+	record.Name = name
+	writeScoreRecords(w, &record)
+}
+
+// parseBan gets the kind and value of a ban out of the query.
+func parseBan(req *http.Request) (storage.BanKind, string, error) {
+	kind := storage.BanKind(req.URL.Query().Get("kind"))
+	if kind != storage.BanSession && kind != storage.BanName {
+		return "", "", fmt.Errorf("kind must be %s or %s", storage.BanSession, storage.BanName)
+	}
+
+	value := strings.TrimSpace(req.URL.Query().Get("value"))
+	if value == "" || strings.Trim(value, "*?") == "" {
+		return "", "", fmt.Errorf("a ban needs a value, and a name pattern can't match everything")
+	}
+
+	return kind, value, nil
+}
+
+// BansEndpoint lists every ban, newest first.
+func (q *QuizAPI) BansEndpoint(w http.ResponseWriter, req *http.Request) {
+	bans, err := q.moderation.Bans()
+	if err != nil {
+		log.Printf("failed to list bans: %s", err)
+		http.Error(w, "failed to list bans", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, bans)
+}
+
+// BanEndpoint bans `?kind=session` for a run id, or `?kind=name` for a name
+// pattern, from registering scores and challenges. A banned session's score
+// is hidden if it's already on the boards.
+func (q *QuizAPI) BanEndpoint(w http.ResponseWriter, req *http.Request) {
+	kind, value, err := parseBan(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ban := storage.Ban{
+		Kind:    kind,
+		Value:   value,
+		Reason:  req.URL.Query().Get("reason"),
+		Created: time.Now(),
+	}
+
+	if err = q.moderation.Ban(ban); err != nil {
+		log.Printf("failed to ban: %s", err)
+		http.Error(w, "failed to ban", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("banned %s '%s'", kind, value)
+	q.audit(req, "ban", fmt.Sprintf("%s:%s", kind, value), ban.Reason)
+
+	if kind == storage.BanSession {
+		q.hideBanned(req, value)
+	}
+
+	writeJSON(w, http.StatusCreated, &ban)
+}
+
+// hideBanned takes a banned session's score off the boards, if it's made it
+// onto them or is waiting to.
+func (q *QuizAPI) hideBanned(req *http.Request, id string) {
+	records, err := q.dataStore.ListScores(storage.ScoreFilter{Id: id})
+	if err != nil {
+		log.Printf("failed to get banned session's score: %s", err)
+		return
+	}
+
+	if len(records) == 0 || (records[0].Status != storage.StatusAccepted && records[0].Status != storage.StatusPending) {
+		return
+	}
+
+	if err = q.dataStore.SetStatus(id, storage.StatusHidden); err != nil {
+		log.Printf("failed to hide banned session's score: %s", err)
+		return
+	}
+
+	q.audit(req, "hide", id, fmt.Sprintf("was %s, banned", records[0].Status))
+}
+
+// UnbanEndpoint lifts the ban with the same kind and value. Anything hidden
+// when it was banned stays hidden.
+func (q *QuizAPI) UnbanEndpoint(w http.ResponseWriter, req *http.Request) {
+	kind, value, err := parseBan(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err = q.moderation.Unban(kind, value); errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "no such ban", http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Printf("failed to unban: %s", err)
+		http.Error(w, "failed to unban", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("unbanned %s '%s'", kind, value)
+	q.audit(req, "unban", fmt.Sprintf("%s:%s", kind, value), "")
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// AuditLogEndpoint is everything admins have done to the boards, newest
+// first.
+func (q *QuizAPI) AuditLogEndpoint(w http.ResponseWriter, req *http.Request) {
+	limit, _ := strconv.Atoi(req.URL.Query().Get("limit"))
+	if limit <= 0 {
+		limit = DEFAULT_AUDIT_LIMIT
+	}
+
+	entries, err := q.moderation.AuditLog(limit)
+	if err != nil {
+		log.Printf("failed to get audit log: %s", err)
+		http.Error(w, "failed to get audit log", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, entries)
+}
@@ -0,0 +1,176 @@
+package api
+
+import (
+	"backend/storage"
+	"backend/types"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+)
+
+// guessOutcome is how a guess went.
+type guessOutcome struct {
+	Correct  bool
+	NearMiss bool // wrong, but the right group, see types.Category
+	Saved    bool // a near miss that used up their save, it doesn't count against them
+	Points   int
+}
+
+// judge works out how a guess after elapsed went under the near miss rule,
+// going by the groups in categories.
+func (q *QuizAPI) judge(categories *types.Registry, claims TokenClaims, guess string, elapsed time.Duration) guessOutcome {
+	outcome := guessOutcome{Correct: guess == claims.Correct}
+	if outcome.Correct {
+		outcome.Points = q.curve.Points(elapsed)
+		return outcome
+	}
+
+	outcome.NearMiss = categories.SameGroup(types.Episode(guess), types.Episode(claims.Correct))
+	if !outcome.NearMiss {
+		return outcome
+	}
+
+	switch q.nearMiss {
+	case NearMissPartial:
+		outcome.Points = int(float64(q.curve.Points(elapsed)) * NEAR_MISS_CREDIT)
+	case NearMissSave:
+		outcome.Saved = !claims.SaveUsed
+	}
+
+	return outcome
+}
+
+// rules is the mode whose rules the run plays by, a challenge replays the
+// challenger's.
+func (c TokenClaims) rules() types.Mode {
+	if c.Mode == types.Challenge && c.Rules != "" {
+		return c.Rules
+	}
+	return c.Mode
+}
+
+// applyGuess updates claims with the outcome of a guess.
+func applyGuess(claims *TokenClaims, outcome guessOutcome) {
+	claims.Answered += 1
+	claims.Points += outcome.Points
+
+	if outcome.Correct {
+		claims.CurrentScore += 1
+		claims.Streak += 1
+		if claims.Streak > claims.BestStreak {
+			claims.BestStreak = claims.Streak
+		}
+		return
+	}
+
+	claims.Streak = 0
+	if outcome.Saved {
+		claims.SaveUsed = true
+		return
+	}
+
+	claims.Wrong += 1
+	if claims.rules() == types.Lives {
+		claims.Lives -= 1
+	}
+}
+
+// runOver decides whether the guess that was just applied ended the run.
+func runOver(claims TokenClaims, outcome guessOutcome) (storage.EndReason, bool) {
+	switch claims.rules() {
+	case types.Lives:
+		return storage.EndOutOfLives, claims.Lives <= 0
+	case types.Round:
+		return storage.EndRoundComplete, claims.Answered >= types.ROUND_LENGTH
+	case types.Blitz:
+		// only the clock ends a blitz
+		return "", false
+	default:
+		// sudden death
+		return storage.EndWrongGuess, !outcome.Correct && !outcome.Saved
+	}
+}
+
+// timeUp is whether a blitz run has used up its budget.
+func timeUp(claims TokenClaims) bool {
+	return claims.rules() == types.Blitz && time.Since(time.Unix(claims.Started, 0)) > types.BLITZ_BUDGET
+}
+
+// dayOver is whether a daily run's day has ended. The recap with every
+// answer goes up at midnight, so nothing after that can count.
+func dayOver(claims TokenClaims) bool {
+	return claims.Mode == types.Daily && claims.Day != types.Today()
+}
+
+// runStats is how a run is going, sent in the Run-Stats header with every
+// clip and at the end.
+type runStats struct {
+	Mode       types.Mode       `json:"mode"`
+	Difficulty types.Difficulty `json:"difficulty"`
+	Score      int              `json:"score"`
+	Answered   int              `json:"answered"`
+	Wrong      int              `json:"wrong"`
+	Points     int              `json:"points"`
+	Streak     int              `json:"streak"`
+	BestStreak int              `json:"bestStreak"`
+	Elapsed    int              `json:"elapsed"` // seconds
+
+	LivesLeft     *int `json:"livesLeft,omitempty"`     // lives only
+	QuestionsLeft *int `json:"questionsLeft,omitempty"` // round only
+	SecondsLeft   *int `json:"secondsLeft,omitempty"`   // blitz only
+
+	// the answer to the clip they just got wrong, if the run carried on
+	// after it
+	Missed types.Episode `json:"missed,omitempty"`
+	// whether the last guess was the right group, and if it used their save
+	NearMiss bool `json:"nearMiss,omitempty"`
+	Saved    bool `json:"saved,omitempty"`
+	SaveUsed bool `json:"saveUsed,omitempty"`
+
+	EndReason storage.EndReason `json:"endReason,omitempty"`
+}
+
+func statsFor(claims TokenClaims) runStats {
+	elapsed := time.Since(time.Unix(claims.Started, 0))
+
+	stats := runStats{
+		Mode:       claims.Mode,
+		Difficulty: claims.Difficulty,
+		Score:      claims.CurrentScore,
+		Answered:   claims.Answered,
+		Wrong:      claims.Wrong,
+		Points:     claims.Points,
+		Streak:     claims.Streak,
+		BestStreak: claims.BestStreak,
+		SaveUsed:   claims.SaveUsed,
+		Elapsed:    int(elapsed / time.Second),
+	}
+
+	switch claims.rules() {
+	case types.Lives:
+		lives := claims.Lives
+		stats.LivesLeft = &lives
+	case types.Round:
+		left := types.ROUND_LENGTH - claims.Answered
+		stats.QuestionsLeft = &left
+	case types.Blitz:
+		left := int((types.BLITZ_BUDGET - elapsed) / time.Second)
+		if left < 0 {
+			left = 0
+		}
+		stats.SecondsLeft = &left
+	}
+
+	return stats
+}
+
+func writeStats(w http.ResponseWriter, stats runStats) {
+	bytes, err := json.Marshal(&stats)
+	if err != nil {
+		log.Printf("failed to marshall run stats: %s", err)
+		return
+	}
+
+	w.Header().Set("Run-Stats", string(bytes))
+}
@@ -0,0 +1,274 @@
+package api
+
+import (
+	"backend/catalog"
+	"backend/storage"
+	"backend/types"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// apiError is a request that can't go any further, and what to tell them.
+type apiError struct {
+	status  int
+	message string
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("%d %s", e.status, e.message)
+}
+
+// turn is what happened on one go at the game: starting a run or guessing a
+// clip, and then either the next clip or the end. Each version of the API
+// turns it into its own kind of response.
+type turn struct {
+	claims TokenClaims
+
+	// the guess, nil when they're just starting out
+	outcome *guessOutcome
+	answer  types.Episode // what the guessed clip actually was
+
+	// set once the run is over
+	reason storage.EndReason
+
+	clip  string // the next clip's file, empty once it's over
+	link  string // signed url for clip, see clipLink
+	token string // for the next guess, or for registering their score
+	stats runStats
+
+	player string // the player token a daily run was counted against
+}
+
+// lost is whether the run ended on a guess that counted against them.
+func (t turn) lost() bool {
+	return t.reason != "" && t.outcome != nil && !t.outcome.Correct && !t.outcome.Saved
+}
+
+// startRun sets up t for a new run from the difficulty and mode in the query.
+func (q *QuizAPI) startRun(req *http.Request, t *turn) *apiError {
+	claims := &t.claims
+	diff := req.URL.Query().Get("difficulty")
+
+	if diff == "" || (diff != string(types.Easy) && diff != string(types.Medium) && diff != string(types.Hard) && diff != string(types.Legend)) {
+		log.Printf("bad params on new request")
+		return &apiError{http.StatusBadRequest, "failed to parse url"}
+	}
+
+	claims.Difficulty = types.Difficulty(diff)
+
+	var err error
+	mode := types.Mode(req.URL.Query().Get("mode"))
+	if mode == "" {
+		mode = types.Classic
+	}
+
+	switch mode {
+	case types.Classic, types.Lives, types.Round, types.Blitz:
+		claims.Mode = mode
+		claims.Ranked = true
+		if mode == types.Lives {
+			claims.Lives = types.LIVES
+		}
+
+		if claims.Seed, err = q.chooser.NewSeed(); err != nil {
+			log.Printf("seed creation error: %s", err)
+			return &apiError{http.StatusInternalServerError, "could not start run"}
+		}
+	case types.Daily:
+		if q.dailySecret == nil {
+			return &apiError{http.StatusBadRequest, "daily mode isn't enabled"}
+		}
+
+		if t.player, err = q.startDaily(req, claims); err != nil {
+			log.Printf("failed to start daily run: %s", err)
+			return &apiError{http.StatusInternalServerError, "could not start run"}
+		}
+	default:
+		log.Printf("bad mode on new request")
+		return &apiError{http.StatusBadRequest, "failed to parse url"}
+	}
+
+	return nil
+}
+
+// startTurn starts a new run from the query, either a difficulty and mode or
+// a challenge code, and hands out the first clip.
+func (q *QuizAPI) startTurn(req *http.Request) (turn, *apiError) {
+	// stick with one version of the manifests even if they're reloaded
+	current := q.manifests.Current()
+
+	if apiErr := q.powGate(req); apiErr != nil {
+		return turn{}, apiErr
+	}
+
+	var t turn
+	t.claims.Id = uuid.New().String()
+
+	// a challenge code instead of a difficulty
+	if code := req.URL.Query().Get("challenge"); code != "" {
+		if err := q.startChallenge(code, &t.claims); err != nil {
+			log.Printf("failed to start challenge: %s", err)
+			return turn{}, &apiError{http.StatusBadRequest, "that's not a valid challenge"}
+		}
+	} else if apiErr := q.startRun(req, &t); apiErr != nil {
+		return turn{}, apiErr
+	}
+
+	t.claims.Started = time.Now().Unix()
+	q.recordStart(req)
+
+	if err := q.history.StartRun(t.claims.Id, t.claims.Difficulty); err != nil {
+		log.Printf("failed to record run start: %s", err)
+	}
+
+	return q.advance(current, t)
+}
+
+// guessTurn checks the token they were handed with the last clip, marks
+// their guess and hands out the next clip, or ends the run.
+func (q *QuizAPI) guessTurn(auth, guess string) (turn, *apiError) {
+	current := q.manifests.Current()
+
+	claims, err := q.parseFromJwt(auth)
+	if err != nil {
+		log.Printf("Token Parse Error: %s", err)
+		return turn{}, &apiError{http.StatusUnauthorized, "Unauthorized"}
+	}
+
+	idBurned, err := q.burned.IsBurned(storage.BurnedId, claims.Id)
+	if err != nil {
+		log.Printf("failed to check id: %s", err)
+		return turn{}, &apiError{http.StatusInternalServerError, "could not check token"}
+	}
+
+	if idBurned {
+		log.Printf("id has been burned")
+		return turn{}, &apiError{http.StatusUnauthorized, "Unauthorized"}
+	}
+
+	// too quick to have listened to it, they can try again
+	elapsed := sinceMillis(claims.Issued)
+	if q.curve.TooFast(elapsed) {
+		log.Printf("answer after %s is too fast", elapsed)
+		return turn{}, &apiError{http.StatusTooEarly, "too fast"}
+	}
+
+	jtiBurned, err := q.burned.Burn(storage.BurnedJti, claims.Jti, time.Unix(claims.Iat, 0).Add(types.TOKEN_LIFETIME))
+	if err != nil {
+		log.Printf("failed to burn jti: %s", err)
+		return turn{}, &apiError{http.StatusInternalServerError, "could not check token"}
+	}
+
+	if jtiBurned {
+		log.Printf("jti has been burned")
+		return turn{}, &apiError{http.StatusUnauthorized, "Unauthorized"}
+	}
+
+	t := turn{claims: claims}
+
+	// a guess after a blitz's time is up doesn't count
+	if timeUp(claims) {
+		return q.finish(t, storage.EndTimeUp)
+	}
+
+	// or after midnight in a daily run
+	if dayOver(claims) {
+		return q.finish(t, storage.EndDayOver)
+	}
+
+	if guess == "" {
+		log.Printf("no guess")
+		return turn{}, &apiError{http.StatusBadRequest, "No guess"}
+	}
+
+	outcome := q.judge(current.Categories, claims, guess, elapsed)
+	t.outcome = &outcome
+	t.answer = types.Episode(claims.Correct)
+	applyGuess(&t.claims, outcome)
+	if elapsed < q.integrity.FastAnswer {
+		t.claims.Fast += 1
+	}
+
+	if err = q.history.RecordGuess(claims.Id, guess, outcome.Correct, t.claims.CurrentScore); err != nil {
+		log.Printf("failed to record guess: %s", err)
+	}
+
+	if reason, over := runOver(t.claims, outcome); over {
+		// that's all folks!
+		return q.finish(t, reason)
+	}
+
+	return q.advance(current, t)
+}
+
+// advance hands out the next clip in the run, or ends it if they've heard
+// them all.
+func (q *QuizAPI) advance(current *catalog.Catalog, t turn) (turn, *apiError) {
+	fileName, episode, ok := q.clipAt(current.Manifests[t.claims.Difficulty], t.claims.Seed, t.claims.Position)
+	if !ok {
+		return q.finish(t, storage.EndPerfectClear)
+	}
+
+	t.claims.Position += 1
+	t.claims.Correct = string(episode)
+	t.claims.Issued = millis(time.Now())
+
+	if err := q.history.RecordClip(t.claims.Id, fileName, episode); err != nil {
+		log.Printf("failed to record clip: %s", err)
+	}
+
+	var err error
+	if t.token, err = q.mintToken(t.claims); err != nil {
+		log.Printf("token creation error: %s", err)
+		return turn{}, &apiError{http.StatusInternalServerError, "could not issue token"}
+	}
+
+	if t.link, err = q.clipLink(fileName); err != nil {
+		log.Printf("clip link creation error: %s", err)
+		return turn{}, &apiError{http.StatusInternalServerError, "could not issue clip"}
+	}
+
+	t.clip = fileName
+	t.stats = statsFor(t.claims)
+	if t.outcome != nil && !t.outcome.Correct {
+		t.stats.Missed = t.answer
+		t.stats.NearMiss = t.outcome.NearMiss
+		t.stats.Saved = t.outcome.Saved
+	}
+
+	return t, nil
+}
+
+// finish ends a run. They get a token with their final score for
+// registering it.
+func (q *QuizAPI) finish(t turn, reason storage.EndReason) (turn, *apiError) {
+	// burn their id for as long as any of their tokens could live
+	if _, err := q.burned.Burn(storage.BurnedId, t.claims.Id, time.Now().Add(types.TOKEN_LIFETIME)); err != nil {
+		log.Printf("failed to burn id: %s", err)
+	}
+
+	if err := q.history.EndRun(t.claims.Id, reason); err != nil {
+		log.Printf("failed to record run end: %s", err)
+	}
+
+	t.reason = reason
+	t.stats = statsFor(t.claims)
+	t.stats.EndReason = reason
+	if t.lost() {
+		t.stats.NearMiss = t.outcome.NearMiss
+	}
+
+	t.claims.Correct = ""
+
+	var err error
+	if t.token, err = q.mintToken(t.claims); err != nil {
+		log.Printf("token creation error: %s", err)
+		return turn{}, &apiError{http.StatusInternalServerError, "could not issue token"}
+	}
+
+	return t, nil
+}
@@ -0,0 +1,314 @@
+package api
+
+import (
+	"backend/keys"
+	"backend/ratelimit"
+	"backend/storage"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"math/bits"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// how long someone has to solve a proof of work and start their run
+const POW_LIFETIME = time.Minute * 2
+
+// ProofOfWork is the optional price of starting a run: find a solution where
+// sha256(`<challenge>:<solution>`) starts with Bits zero bits. Challenges are
+// signed, so there's nothing to remember about them until they're used.
+// When a client, or everyone together, starts more than ClientRate or
+// GlobalRate runs a minute, each doubling over adds a bit, up to MaxBits.
+type ProofOfWork struct {
+	Bits       int // 0 turns it off
+	MaxBits    int
+	ClientRate int
+	GlobalRate int
+}
+
+// ParseProofOfWork reads a list like `bits=16,max=24,client=10,global=600`.
+// Without bits it's off.
+func ParseProofOfWork(value string) (ProofOfWork, error) {
+	pow := ProofOfWork{ClientRate: 10, GlobalRate: 600}
+	if value == "" {
+		return pow, nil
+	}
+
+	for _, pair := range strings.Split(value, ",") {
+		parts := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(parts) != 2 {
+			return ProofOfWork{}, fmt.Errorf("expected name=value, got '%s'", pair)
+		}
+
+		number, err := strconv.Atoi(parts[1])
+		if err != nil || number < 0 {
+			return ProofOfWork{}, fmt.Errorf("bad %s '%s'", parts[0], parts[1])
+		}
+
+		switch parts[0] {
+		case "bits":
+			pow.Bits = number
+		case "max":
+			pow.MaxBits = number
+		case "client":
+			pow.ClientRate = number
+		case "global":
+			pow.GlobalRate = number
+		default:
+			return ProofOfWork{}, fmt.Errorf("unknown setting '%s'", parts[0])
+		}
+	}
+
+	if pow.MaxBits == 0 {
+		pow.MaxBits = pow.Bits + 8
+	}
+
+	if pow.MaxBits < pow.Bits || pow.MaxBits > 32 {
+		return ProofOfWork{}, fmt.Errorf("max %d must be between bits and 32", pow.MaxBits)
+	}
+
+	if pow.ClientRate == 0 || pow.GlobalRate == 0 {
+		return ProofOfWork{}, fmt.Errorf("client and global rates must be positive")
+	}
+
+	return pow, nil
+}
+
+func (p ProofOfWork) String() string {
+	if p.Bits == 0 {
+		return "off"
+	}
+	return fmt.Sprintf("bits=%d,max=%d,client=%d,global=%d", p.Bits, p.MaxBits, p.ClientRate, p.GlobalRate)
+}
+
+// startRates counts runs started in this window and the last one, per
+// client and overall.
+type startRates struct {
+	lock   sync.Mutex
+	window time.Duration
+
+	period        int64
+	current, last map[string]int
+	currentTotal  int
+	lastTotal     int
+}
+
+func (r *startRates) roll(now time.Time) {
+	period := now.UnixNano() / int64(r.window)
+	if period == r.period {
+		return
+	}
+
+	if period == r.period+1 {
+		r.last, r.lastTotal = r.current, r.currentTotal
+	} else {
+		r.last, r.lastTotal = nil, 0
+	}
+
+	r.period = period
+	r.current, r.currentTotal = make(map[string]int), 0
+}
+
+func (r *startRates) record(client string, now time.Time) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.roll(now)
+	r.current[client] += 1
+	r.currentTotal += 1
+}
+
+// rates is about how many runs client and everyone have started in the last
+// window, sliding across the two counted ones.
+func (r *startRates) rates(client string, now time.Time) (float64, float64) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.roll(now)
+	weight := 1 - float64(now.UnixNano()%int64(r.window))/float64(r.window)
+
+	return float64(r.current[client]) + float64(r.last[client])*weight, float64(r.currentTotal) + float64(r.lastTotal)*weight
+}
+
+// powBits is how hard client's next challenge is.
+func (q *QuizAPI) powBits(client string) int {
+	clientRate, globalRate := q.recentStarts.rates(client, time.Now())
+
+	extra := 0
+	for _, over := range []float64{clientRate / float64(q.pow.ClientRate), globalRate / float64(q.pow.GlobalRate)} {
+		if over > 1 {
+			if bits := 1 + int(math.Log2(over)); bits > extra {
+				extra = bits
+			}
+		}
+	}
+
+	if q.pow.Bits+extra > q.pow.MaxBits {
+		return q.pow.MaxBits
+	}
+	return q.pow.Bits + extra
+}
+
+func powSignature(key *keys.Key, payload string) string {
+	mac := hmac.New(sha256.New, key.SignatureKey)
+	mac.Write([]byte("pow|" + payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// powChallenge is `<kid>.<expires>.<bits>.<nonce>.<sig>`.
+func (q *QuizAPI) powChallenge(bits int) (string, time.Time, error) {
+	nonce := make([]byte, 12)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to make nonce: %w", err)
+	}
+
+	key := q.keyRing.Current()
+	expires := time.Now().Add(POW_LIFETIME)
+	payload := fmt.Sprintf("%s.%d.%d.%s", key.Id, expires.Unix(), bits, base64.RawURLEncoding.EncodeToString(nonce))
+
+	return payload + "." + powSignature(&key, payload), expires, nil
+}
+
+// leadingZeros is how many zero bits hash starts with.
+func leadingZeros(hash []byte) int {
+	zeros := 0
+	for _, b := range hash {
+		if b != 0 {
+			return zeros + bits.LeadingZeros8(b)
+		}
+		zeros += 8
+	}
+	return zeros
+}
+
+// errPowTooEasy is a challenge handed out before the client's difficulty
+// went up. Otherwise they could stock up on easy ones and spend them all at
+// once.
+var errPowTooEasy = errors.New("challenge is easier than the client's difficulty now")
+
+// checkPow checks the challenge is ours and hasn't expired, that it's at
+// least minBits hard, that solution solves it, and that it hasn't been used
+// already.
+func (q *QuizAPI) checkPow(challenge, solution string, minBits int) error {
+	parts := strings.Split(challenge, ".")
+	if len(parts) != 5 {
+		return fmt.Errorf("malformed challenge")
+	}
+
+	key, ok := q.keyRing.Lookup(parts[0])
+	if !ok {
+		return fmt.Errorf("unknown or expired key: %s", parts[0])
+	}
+
+	if !hmac.Equal([]byte(parts[4]), []byte(powSignature(&key, strings.Join(parts[:4], ".")))) {
+		return fmt.Errorf("bad challenge signature")
+	}
+
+	expiresUnix, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return fmt.Errorf("bad expiry: %w", err)
+	}
+
+	expires := time.Unix(expiresUnix, 0)
+	if time.Now().After(expires) {
+		return fmt.Errorf("challenge expired at %s", expires)
+	}
+
+	bits, err := strconv.Atoi(parts[2])
+	if err != nil {
+		return fmt.Errorf("bad difficulty: %w", err)
+	}
+
+	if bits < minBits {
+		return fmt.Errorf("%d bits, now %d: %w", bits, minBits, errPowTooEasy)
+	}
+
+	hash := sha256.Sum256([]byte(challenge + ":" + solution))
+	if leadingZeros(hash[:]) < bits {
+		return fmt.Errorf("solution doesn't solve the challenge")
+	}
+
+	used, err := q.burned.Burn(storage.BurnedPow, parts[3], expires)
+	if err != nil {
+		return fmt.Errorf("failed to burn challenge: %w", err)
+	}
+
+	if used {
+		return fmt.Errorf("challenge already used")
+	}
+
+	return nil
+}
+
+// powGate is the proof of work check for starting a run from req, if it's
+// turned on.
+func (q *QuizAPI) powGate(req *http.Request) *apiError {
+	if q.pow.Bits == 0 {
+		return nil
+	}
+
+	challenge := req.URL.Query().Get("pow")
+	if challenge == "" {
+		return &apiError{http.StatusPreconditionRequired, "solve a proof of work first, see /clipquiz/v1/pow"}
+	}
+
+	err := q.checkPow(challenge, req.URL.Query().Get("solution"), q.powBits(ratelimit.ClientIP(req)))
+	if errors.Is(err, errPowTooEasy) {
+		log.Printf("stale proof of work: %s", err)
+		return &apiError{http.StatusPreconditionRequired, "that proof of work is too easy now, solve a new one"}
+	}
+	if err != nil {
+		log.Printf("bad proof of work: %s", err)
+		return &apiError{http.StatusForbidden, "bad proof of work"}
+	}
+
+	return nil
+}
+
+type powResponse struct {
+	Challenge  string `json:"challenge"`
+	Difficulty int    `json:"difficulty"`
+	Expires    int64  `json:"expires"`
+}
+
+// PowEndpoint hands out a proof of work challenge for starting a run.
+func (q *QuizAPI) PowEndpoint(w http.ResponseWriter, req *http.Request) {
+	if q.pow.Bits == 0 {
+		http.NotFound(w, req)
+		return
+	}
+
+	bits := q.powBits(ratelimit.ClientIP(req))
+
+	challenge, expires, err := q.powChallenge(bits)
+	if err != nil {
+		log.Printf("failed to make proof of work challenge: %s", err)
+		http.Error(w, "could not make challenge", http.StatusInternalServerError)
+		return
+	}
+
+	bytes, err := json.Marshal(&powResponse{
+		Challenge:  challenge,
+		Difficulty: bits,
+		Expires:    expires.Unix(),
+	})
+	if err != nil {
+		log.Printf("failed to marshall proof of work: %s", err)
+		http.Error(w, "failed to marshall proof of work!", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(bytes)
+}
@@ -0,0 +1,77 @@
+package api
+
+import (
+	"backend/keys"
+	"backend/storage"
+	"crypto/sha256"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// solvePow finds a solution to challenge at bits.
+func solvePow(challenge string, bits int) string {
+	for solution := 0; ; solution++ {
+		hash := sha256.Sum256([]byte(challenge + ":" + strconv.Itoa(solution)))
+		if leadingZeros(hash[:]) >= bits {
+			return strconv.Itoa(solution)
+		}
+	}
+}
+
+func TestPowStockpile(t *testing.T) {
+	ring := keys.NewRing(time.Hour)
+	if err := ring.Generate(); err != nil {
+		t.Fatal(err)
+	}
+
+	q := &QuizAPI{
+		keyRing: ring,
+		burned:  storage.NewMemoryBurnRegistry(),
+		pow:     ProofOfWork{Bits: 2, MaxBits: 10, ClientRate: 2, GlobalRate: 1000},
+	}
+	q.recentStarts.window = time.Minute
+
+	const client = "203.0.113.9"
+
+	// stock up while it's cheap
+	bits := q.powBits(client)
+	var stockpile []string
+	for i := 0; i < 10; i++ {
+		challenge, _, err := q.powChallenge(bits)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stockpile = append(stockpile, challenge)
+	}
+
+	spent := 0
+	for _, challenge := range stockpile {
+		err := q.checkPow(challenge, solvePow(challenge, bits), q.powBits(client))
+		if errors.Is(err, errPowTooEasy) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		q.recentStarts.record(client, time.Now())
+		spent++
+	}
+
+	// the first two are under the rate, then it's a bit harder
+	if spent != q.pow.ClientRate+1 {
+		t.Errorf("spent %d stocked up challenges, want %d", spent, q.pow.ClientRate+1)
+	}
+
+	// a fresh one at the new difficulty works
+	harder := q.powBits(client)
+	challenge, _, err := q.powChallenge(harder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := q.checkPow(challenge, solvePow(challenge, harder), q.powBits(client)); err != nil {
+		t.Errorf("a challenge at the current difficulty was refused: %s", err)
+	}
+}
@@ -0,0 +1,136 @@
+package api
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Curve turns how long someone took to answer a clip into points. Answers
+// inside Grace get MaxPoints, then it falls off over Window down to
+// MinPoints. Anything faster than Floor is impossible and gets rejected.
+type Curve struct {
+	Shape     string // linear, exponential or flat
+	MaxPoints int
+	MinPoints int
+	Grace     time.Duration
+	Window    time.Duration
+	Floor     time.Duration
+}
+
+// DefaultCurve is what SCORING_CURVE starts from.
+var DefaultCurve = Curve{
+	Shape:     "linear",
+	MaxPoints: 1000,
+	MinPoints: 100,
+	Grace:     time.Second,
+	Window:    time.Second * 14,
+	Floor:     time.Millisecond * 250,
+}
+
+// ParseCurve reads a list like `shape=exponential,max=500,window=10s`, any
+// setting that's left out comes from DefaultCurve.
+func ParseCurve(value string) (Curve, error) {
+	curve := DefaultCurve
+	if value == "" {
+		return curve, nil
+	}
+
+	for _, pair := range strings.Split(value, ",") {
+		parts := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(parts) != 2 {
+			return Curve{}, fmt.Errorf("expected name=value, got '%s'", pair)
+		}
+
+		var err error
+		switch parts[0] {
+		case "shape":
+			if parts[1] != "linear" && parts[1] != "exponential" && parts[1] != "flat" {
+				return Curve{}, fmt.Errorf("unknown shape '%s', must be linear, exponential or flat", parts[1])
+			}
+			curve.Shape = parts[1]
+		case "max":
+			curve.MaxPoints, err = strconv.Atoi(parts[1])
+		case "min":
+			curve.MinPoints, err = strconv.Atoi(parts[1])
+		case "grace":
+			curve.Grace, err = time.ParseDuration(parts[1])
+		case "window":
+			curve.Window, err = time.ParseDuration(parts[1])
+		case "floor":
+			curve.Floor, err = time.ParseDuration(parts[1])
+		default:
+			return Curve{}, fmt.Errorf("unknown setting '%s'", parts[0])
+		}
+
+		if err != nil {
+			return Curve{}, fmt.Errorf("bad %s: %w", parts[0], err)
+		}
+	}
+
+	if curve.MinPoints > curve.MaxPoints {
+		return Curve{}, fmt.Errorf("min %d is more than max %d", curve.MinPoints, curve.MaxPoints)
+	}
+
+	if curve.Window <= 0 {
+		return Curve{}, fmt.Errorf("window must be positive")
+	}
+
+	return curve, nil
+}
+
+func (c Curve) String() string {
+	return fmt.Sprintf("shape=%s,max=%d,min=%d,grace=%s,window=%s,floor=%s", c.Shape, c.MaxPoints, c.MinPoints, c.Grace, c.Window, c.Floor)
+}
+
+// TooFast is whether an answer after elapsed is quicker than anyone could
+// manage.
+func (c Curve) TooFast(elapsed time.Duration) bool {
+	return elapsed < c.Floor
+}
+
+// Points is what a correct answer after elapsed is worth.
+func (c Curve) Points(elapsed time.Duration) int {
+	late := elapsed - c.Grace
+	if c.Shape == "flat" || late <= 0 {
+		return c.MaxPoints
+	}
+
+	if late >= c.Window {
+		return c.MinPoints
+	}
+
+	spread := float64(c.MaxPoints - c.MinPoints)
+	progress := float64(late) / float64(c.Window)
+
+	switch c.Shape {
+	case "exponential":
+		// down to 1% of the spread at the end of the window
+		return c.MinPoints + int(math.Round(spread*math.Pow(0.01, progress)))
+	default:
+		return c.MinPoints + int(math.Round(spread*(1-progress)))
+	}
+}
+
+// NearMissRule is what happens when someone guesses the wrong film from the
+// right trilogy, or whatever group the categories file puts it in.
+type NearMissRule string
+
+const (
+	NearMissOff     NearMissRule = ""        // it's just wrong
+	NearMissPartial NearMissRule = "partial" // still wrong, but worth NEAR_MISS_CREDIT of a right answer
+	NearMissSave    NearMissRule = "save"    // the first one in a run doesn't count against them
+)
+
+const NEAR_MISS_CREDIT = 0.5
+
+func ParseNearMissRule(value string) (NearMissRule, error) {
+	switch rule := NearMissRule(value); rule {
+	case NearMissOff, NearMissPartial, NearMissSave:
+		return rule, nil
+	default:
+		return "", fmt.Errorf("unknown near miss rule '%s', must be partial or save", value)
+	}
+}
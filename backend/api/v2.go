@@ -0,0 +1,110 @@
+package api
+
+import (
+	"backend/storage"
+	"backend/types"
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+// The v2 game API answers every start and guess with a result document
+// instead of signalling with status codes, and the clip itself comes from a
+// signed link, see ClipLinks. Everything besides playing is still under v1.
+
+type clipRef struct {
+	Url string `json:"url"`
+}
+
+type resultDoc struct {
+	// how the guess went, nil when they're just starting out
+	Correct  *bool         `json:"correct,omitempty"`
+	Answer   types.Episode `json:"answer,omitempty"`
+	NearMiss bool          `json:"nearMiss"`
+
+	Score  int  `json:"score"`
+	Points int  `json:"points"`
+	Ranked bool `json:"ranked"`
+
+	Over      bool              `json:"over"`
+	EndReason storage.EndReason `json:"endReason,omitempty"`
+
+	// for the next guess, or for registering their score once it's over
+	Token string `json:"token"`
+	// nil once it's over
+	Clip *clipRef `json:"clip"`
+
+	Stats runStats `json:"stats"`
+
+	// who a daily run was counted against, send it back in Player-Token
+	Player string `json:"player,omitempty"`
+}
+
+type errorDoc struct {
+	Error string `json:"error"`
+	// machine readable, for errors the frontend explains itself
+	Reason string `json:"reason,omitempty"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, doc interface{}) {
+	bytes, err := json.Marshal(doc)
+	if err != nil {
+		log.Printf("failed to marshall response: %s", err)
+		http.Error(w, "failed to marshall response!", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(bytes)
+}
+
+func (q *QuizAPI) writeTurn(w http.ResponseWriter, status int, t turn, apiErr *apiError) {
+	if apiErr != nil {
+		writeJSON(w, apiErr.status, errorDoc{Error: apiErr.message})
+		return
+	}
+
+	doc := resultDoc{
+		Score:     t.claims.CurrentScore,
+		Points:    t.claims.Points,
+		Ranked:    t.claims.Ranked,
+		Over:      t.reason != "",
+		EndReason: t.reason,
+		Token:     t.token,
+		Stats:     t.stats,
+		Player:    t.player,
+	}
+
+	if t.outcome != nil {
+		doc.Correct = &t.outcome.Correct
+		doc.Answer = t.answer
+		doc.NearMiss = t.outcome.NearMiss
+	}
+
+	if t.link != "" {
+		doc.Clip = &clipRef{Url: t.link}
+	}
+
+	writeJSON(w, status, doc)
+}
+
+// StartRunV2Endpoint starts a run from the same query as v1, a difficulty and
+// mode or a challenge code.
+func (q *QuizAPI) StartRunV2Endpoint(w http.ResponseWriter, req *http.Request) {
+	t, apiErr := q.startTurn(req)
+	q.writeTurn(w, http.StatusCreated, t, apiErr)
+}
+
+// GuessV2Endpoint takes the token from the last result in Auth-Token and
+// their guess in the query.
+func (q *QuizAPI) GuessV2Endpoint(w http.ResponseWriter, req *http.Request) {
+	auth := req.Header.Get("Auth-Token")
+	if auth == "" {
+		writeJSON(w, http.StatusUnauthorized, errorDoc{Error: "you need a token"})
+		return
+	}
+
+	t, apiErr := q.guessTurn(auth, req.URL.Query().Get("guess"))
+	q.writeTurn(w, http.StatusOK, t, apiErr)
+}
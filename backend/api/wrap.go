@@ -0,0 +1,152 @@
+package api
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// the most random padding that goes on the end of a wrapped clip, so its
+// length doesn't give it away either
+const MAX_CLIP_PADDING = 8 * 1024
+
+// the clip's real length goes first, inside the encryption, as a big endian
+// uint32
+const CLIP_LENGTH_PREFIX = 4
+
+// clipWrap is one serve's worth of extra encryption over a clip's .enc file.
+// Every .enc file is the same bytes every time, so without it you could hash
+// a response once and know the answer forever. The outer layer is AES-128-CTR
+// under a fresh key, which is cheap, and the client gets the key in the
+// Clip-Key header to take it back off. What's under it is the clip's length,
+// the clip, then the padding, so nothing in the clear says how big it is.
+type clipWrap struct {
+	key     [16]byte
+	iv      [aes.BlockSize]byte
+	size    int64
+	padding int64
+}
+
+func newClipWrap(size int64) (clipWrap, error) {
+	if size < 0 || size > math.MaxUint32 {
+		return clipWrap{}, fmt.Errorf("can't wrap a clip of %d bytes", size)
+	}
+
+	wrap := clipWrap{size: size}
+
+	var random [16 + aes.BlockSize + 2]byte
+	if _, err := rand.Read(random[:]); err != nil {
+		return clipWrap{}, fmt.Errorf("failed to make clip key: %w", err)
+	}
+
+	copy(wrap.key[:], random[:16])
+	copy(wrap.iv[:], random[16:16+aes.BlockSize])
+	wrap.padding = int64(binary.BigEndian.Uint16(random[16+aes.BlockSize:]) % (MAX_CLIP_PADDING + 1))
+
+	return wrap, nil
+}
+
+// header is `<key>.<iv>` in hex.
+func (c clipWrap) header() string {
+	return hex.EncodeToString(c.key[:]) + "." + hex.EncodeToString(c.iv[:])
+}
+
+// length is how many bytes write sends.
+func (c clipWrap) length() int64 {
+	return CLIP_LENGTH_PREFIX + c.size + c.padding
+}
+
+// write encrypts the clip's length, the clip from src and then the padding
+// into dst.
+func (c clipWrap) write(dst io.Writer, src io.Reader) error {
+	block, err := aes.NewCipher(c.key[:])
+	if err != nil {
+		return err
+	}
+
+	out := cipher.StreamWriter{S: cipher.NewCTR(block, c.iv[:]), W: dst}
+
+	var prefix [CLIP_LENGTH_PREFIX]byte
+	binary.BigEndian.PutUint32(prefix[:], uint32(c.size))
+	if _, err := out.Write(prefix[:]); err != nil {
+		return fmt.Errorf("failed to write clip length: %w", err)
+	}
+
+	if _, err := io.CopyN(out, src, c.size); err != nil {
+		return fmt.Errorf("failed to write clip: %w", err)
+	}
+
+	// zeros are fine, they come out of the cipher as noise
+	if _, err := io.CopyN(out, zeros{}, c.padding); err != nil {
+		return fmt.Errorf("failed to write padding: %w", err)
+	}
+
+	return nil
+}
+
+type zeros struct{}
+
+func (zeros) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
+
+// serveClip sends the clip fileName from the clip directory, wrapped if
+// that's on. Every way a clip gets out goes through here or the media
+// endpoint, which wraps too.
+func (q *QuizAPI) serveClip(w http.ResponseWriter, req *http.Request, fileName string) {
+	filePath := filepath.Join(q.clipDir, fileName) + ".enc"
+	if q.wrapClips {
+		serveWrapped(w, req, filePath)
+		return
+	}
+
+	http.ServeFile(w, req, filePath)
+}
+
+// serveWrapped sends the clip at filePath wrapped, see clipWrap.
+func serveWrapped(w http.ResponseWriter, req *http.Request, filePath string) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		log.Printf("failed to open clip: %s", err)
+		http.NotFound(w, req)
+		return
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		log.Printf("failed to stat clip: %s", err)
+		http.Error(w, "failed to read clip", http.StatusInternalServerError)
+		return
+	}
+
+	wrap, err := newClipWrap(info.Size())
+	if err != nil {
+		log.Printf("failed to wrap clip: %s", err)
+		http.Error(w, "failed to read clip", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Clip-Key", wrap.header())
+	w.Header().Set("Content-Length", strconv.FormatInt(wrap.length(), 10))
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Cache-Control", "no-store")
+
+	if err := wrap.write(w, file); err != nil {
+		// too late to tell them
+		log.Printf("failed to serve wrapped clip: %s", err)
+	}
+}
@@ -0,0 +1,163 @@
+package api
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// clips are a few seconds of opus, tens to a couple hundred KB
+var clipSizes = []int{32 * 1024, 128 * 1024, 512 * 1024}
+
+func randomClip(b *testing.B, size int) []byte {
+	clip := make([]byte, size)
+	if _, err := rand.Read(clip); err != nil {
+		b.Fatal(err)
+	}
+	return clip
+}
+
+// BenchmarkPlainClip is what serving a clip as-is costs, to compare against.
+func BenchmarkPlainClip(b *testing.B) {
+	for _, size := range clipSizes {
+		clip := randomClip(b, size)
+
+		b.Run(fmt.Sprintf("%dKB", size/1024), func(b *testing.B) {
+			b.SetBytes(int64(size))
+			for i := 0; i < b.N; i++ {
+				if _, err := io.CopyN(io.Discard, bytes.NewReader(clip), int64(size)); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkWrapClip(b *testing.B) {
+	for _, size := range clipSizes {
+		clip := randomClip(b, size)
+
+		b.Run(fmt.Sprintf("%dKB", size/1024), func(b *testing.B) {
+			b.SetBytes(int64(size))
+			for i := 0; i < b.N; i++ {
+				wrap, err := newClipWrap(int64(size))
+				if err != nil {
+					b.Fatal(err)
+				}
+
+				if err := wrap.write(io.Discard, bytes.NewReader(clip)); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// unwrap takes a wrapped serve back off with its Clip-Key header, like the
+// frontend does.
+func unwrap(t *testing.T, header string, wrapped []byte) []byte {
+	parts := strings.Split(header, ".")
+	if len(parts) != 2 {
+		t.Fatalf("bad Clip-Key %q", header)
+	}
+
+	key, err := hex.DecodeString(parts[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	iv, err := hex.DecodeString(parts[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	unwrapped := make([]byte, len(wrapped))
+	cipher.NewCTR(block, iv).XORKeyStream(unwrapped, wrapped)
+
+	size := int(binary.BigEndian.Uint32(unwrapped))
+	if CLIP_LENGTH_PREFIX+size > len(unwrapped) {
+		t.Fatalf("wrapped length %d is longer than the %d bytes sent", size, len(wrapped))
+	}
+	return unwrapped[CLIP_LENGTH_PREFIX : CLIP_LENGTH_PREFIX+size]
+}
+
+func TestWrapRoundTrip(t *testing.T) {
+	clip := make([]byte, clipSizes[1])
+	if _, err := rand.Read(clip); err != nil {
+		t.Fatal(err)
+	}
+
+	filePath := filepath.Join(t.TempDir(), "clip.enc")
+	if err := os.WriteFile(filePath, clip, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var serves [][]byte
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		serveWrapped(w, httptest.NewRequest(http.MethodGet, "/clip", nil), filePath)
+
+		body := w.Body.Bytes()
+		if length := w.Header().Get("Content-Length"); length != strconv.Itoa(len(body)) {
+			t.Errorf("Content-Length is %s but sent %d bytes", length, len(body))
+		}
+
+		if bytes.Contains(body, clip[:64]) {
+			t.Error("the clip went out as it is")
+		}
+
+		header := w.Header().Get("Clip-Key")
+		if strings.Contains(header, strconv.Itoa(len(clip))) {
+			t.Errorf("Clip-Key %q gives away the clip's length", header)
+		}
+
+		if !bytes.Equal(unwrap(t, header, body), clip) {
+			t.Fatal("unwrapped clip doesn't match")
+		}
+
+		serves = append(serves, body)
+	}
+
+	if bytes.Equal(serves[0][:len(clip)], serves[1][:len(clip)]) {
+		t.Error("two serves of the same clip came out the same")
+	}
+}
+
+// BenchmarkUnwrapClip is what taking the wrapping off costs the client.
+func BenchmarkUnwrapClip(b *testing.B) {
+	clip := randomClip(b, clipSizes[1])
+
+	wrap, err := newClipWrap(int64(len(clip)))
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	var wrapped bytes.Buffer
+	if err := wrap.write(&wrapped, bytes.NewReader(clip)); err != nil {
+		b.Fatal(err)
+	}
+
+	b.SetBytes(int64(len(clip)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		block, _ := aes.NewCipher(wrap.key[:])
+		unwrapped := make([]byte, wrapped.Len())
+		cipher.NewCTR(block, wrap.iv[:]).XORKeyStream(unwrapped, wrapped.Bytes())
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"backend/catalog"
+	"backend/storage"
+	"backend/types"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+)
+
+// calibrateCommand proposes new difficulty manifests based on how often
+// players get each clip right. It writes them to a directory so they can be
+// looked over before replacing the real ones.
+func calibrateCommand(args []string) int {
+	flags := flag.NewFlagSet("calibrate", flag.ExitOnError)
+	outDir := flags.String("out", "calibrated", "directory to write the proposed manifests to")
+	minGuesses := flags.Int("min-guesses", 30, "clips with fewer guesses than this stay where they are")
+	easyRate := flags.Float64("easy", 0.75, "clips guessed right at least this often are easy")
+	mediumRate := flags.Float64("medium", 0.5, "clips guessed right at least this often are medium")
+	hardRate := flags.Float64("hard", 0.3, "clips guessed right at least this often are hard, anything lower is legend")
+	flags.Parse(args)
+
+	current, err := catalog.Load(manifestPathFromEnv(), os.Getenv("CATEGORIES_FILE"), "")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to load manifests: %s\n", err)
+		return 1
+	}
+	manifests, categories := current.Manifests, current.Categories
+
+	dataStore, err := storage.Open(databaseFromEnv())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to open database: %s\n", err)
+		return 1
+	}
+	defer dataStore.Close()
+
+	history, err := storage.NewHistoryStore(dataStore)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to open run history: %s\n", err)
+		return 1
+	}
+
+	stats, err := history.ClipStats()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to get clip stats: %s\n", err)
+		return 1
+	}
+
+	statsByClip := make(map[string]storage.ClipStats, len(stats))
+	for _, stat := range stats {
+		statsByClip[stat.Clip] = stat
+	}
+
+	proposed := make(map[types.Difficulty]types.Manifest, len(manifests))
+	for difficulty := range manifests {
+		proposed[difficulty] = make(types.Manifest, len(categories.Categories))
+		for _, episode := range categories.Ids() {
+			proposed[difficulty][episode] = []string{}
+		}
+	}
+
+	moved := 0
+	for _, difficulty := range []types.Difficulty{types.Easy, types.Medium, types.Hard, types.Legend} {
+		manifest, ok := manifests[difficulty]
+		if !ok {
+			continue
+		}
+
+		clips := append([]string(nil), manifest.Keys...)
+		sort.Strings(clips)
+
+		for _, clip := range clips {
+			target := difficulty
+
+			stat, ok := statsByClip[clip]
+			if ok && stat.Guessed >= *minGuesses {
+				rate := stat.CorrectRate()
+				switch {
+				case rate >= *easyRate:
+					target = types.Easy
+				case rate >= *mediumRate:
+					target = types.Medium
+				case rate >= *hardRate:
+					target = types.Hard
+				default:
+					target = types.Legend
+				}
+			}
+
+			if target != difficulty {
+				fmt.Printf("%s: %s -> %s (right %.0f%% of %d guesses)\n", clip, difficulty, target, stat.CorrectRate()*100, stat.Guessed)
+				moved++
+			}
+
+			episode := manifest.Lookup[clip]
+			proposed[target][episode] = append(proposed[target][episode], clip)
+		}
+	}
+
+	if err = os.MkdirAll(*outDir, 0755); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to create %s: %s\n", *outDir, err)
+		return 1
+	}
+
+	for difficulty, manifest := range proposed {
+		bytes, err := json.MarshalIndent(manifest, "", "\t")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to marshal %s: %s\n", difficulty, err)
+			return 1
+		}
+
+		fullPath := path.Join(*outDir, fmt.Sprintf("%s.json", string(difficulty)))
+		if err = ioutil.WriteFile(fullPath, bytes, 0644); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to write %s: %s\n", fullPath, err)
+			return 1
+		}
+	}
+
+	fmt.Printf("%d clips moved, proposed manifests written to %s\n", moved, *outDir)
+	return 0
+}
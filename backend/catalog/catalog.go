@@ -0,0 +1,224 @@
+package catalog
+
+import (
+	"backend/types"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Catalog is one consistent set of manifests and the categories they use.
+// Once loaded it is never modified, a reload makes a new one.
+type Catalog struct {
+	Manifests  map[types.Difficulty]types.RandomManifest
+	Categories *types.Registry
+	Loaded     time.Time
+}
+
+// CategoriesFile is where the categories for dir live when no file is given.
+func CategoriesFile(dir string) string {
+	return path.Join(dir, "categories.json")
+}
+
+// ManifestFile is where the manifest for difficulty lives in dir.
+func ManifestFile(dir string, difficulty types.Difficulty) string {
+	return path.Join(dir, fmt.Sprintf("%s.json", string(difficulty)))
+}
+
+// LoadCategories reads categoriesFile, or categories.json in dir if that's
+// empty, or falls back to the six films if neither exist.
+func LoadCategories(dir, categoriesFile string) (*types.Registry, error) {
+	if categoriesFile == "" {
+		categoriesFile = CategoriesFile(dir)
+		if _, err := os.Stat(categoriesFile); os.IsNotExist(err) {
+			return types.DefaultRegistry(), nil
+		}
+	}
+
+	return types.LoadRegistry(categoriesFile)
+}
+
+// LoadManifest reads and parses one difficulty's manifest file.
+func LoadManifest(dir string, difficulty types.Difficulty) (types.Manifest, error) {
+	fullPath := ManifestFile(dir, difficulty)
+
+	bytes, err := ioutil.ReadFile(fullPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read '%s': %w", fullPath, err)
+	}
+
+	var manifest types.Manifest
+	if err = json.Unmarshal(bytes, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse '%s': %w", fullPath, err)
+	}
+
+	return manifest, nil
+}
+
+// Load reads every difficulty's manifest in dir and checks they make sense
+// together. If clipDir isn't empty every clip must have a file in it.
+func Load(dir, categoriesFile, clipDir string) (*Catalog, error) {
+	categories, err := LoadCategories(dir, categoriesFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load categories: %w", err)
+	}
+
+	c := &Catalog{
+		Manifests:  make(map[types.Difficulty]types.RandomManifest, 4),
+		Categories: categories,
+		Loaded:     time.Now(),
+	}
+
+	for _, difficulty := range []types.Difficulty{types.Easy, types.Medium, types.Hard, types.Legend} {
+		manifest, err := LoadManifest(dir, difficulty)
+		if err != nil {
+			return nil, err
+		}
+
+		for episode := range manifest {
+			if _, ok := categories.Get(episode); !ok {
+				return nil, fmt.Errorf("%s has clips for unknown category '%s'", difficulty, episode)
+			}
+		}
+
+		var rm types.RandomManifest
+		totalSize := manifest.TotalSize()
+
+		if totalSize == 0 {
+			return nil, fmt.Errorf("%s has no clips", difficulty)
+		}
+
+		rm.Lookup = make(map[string]types.Episode, totalSize)
+		rm.Keys = make([]string, 0, totalSize)
+
+		for _, episode := range categories.Ids() {
+			for _, clip := range manifest[episode] {
+				if previous, ok := rm.Lookup[clip]; ok {
+					return nil, fmt.Errorf("%s lists clip %s under both '%s' and '%s'", difficulty, clip, previous, episode)
+				}
+
+				if clipDir != "" {
+					if _, err := os.Stat(filepath.Join(clipDir, clip) + ".enc"); err != nil {
+						return nil, fmt.Errorf("%s clip %s: %w", difficulty, clip, err)
+					}
+				}
+
+				rm.Lookup[clip] = episode
+				rm.Keys = append(rm.Keys, clip)
+			}
+		}
+
+		c.Manifests[difficulty] = rm
+	}
+
+	return c, nil
+}
+
+// Source hands out the current Catalog and swaps in a new one on Reload.
+// Callers should grab Current() once per request so they see one version.
+type Source struct {
+	dir            string
+	categoriesFile string
+	clipDir        string
+
+	current atomic.Value // *Catalog
+
+	// only one reload at a time
+	reloadLock sync.Mutex
+}
+
+// NewSource does the first load, failing if it can't.
+func NewSource(dir, categoriesFile, clipDir string) (*Source, error) {
+	s := &Source{dir: dir, categoriesFile: categoriesFile, clipDir: clipDir}
+
+	c, err := Load(dir, categoriesFile, clipDir)
+	if err != nil {
+		return nil, err
+	}
+
+	s.current.Store(c)
+	return s, nil
+}
+
+// Current returns the catalog to use for a request.
+func (s *Source) Current() *Catalog {
+	return s.current.Load().(*Catalog)
+}
+
+// Reload loads everything again and swaps it in. If anything is wrong the
+// error is returned and the old catalog stays.
+func (s *Source) Reload() (*Catalog, error) {
+	s.reloadLock.Lock()
+	defer s.reloadLock.Unlock()
+
+	c, err := Load(s.dir, s.categoriesFile, s.clipDir)
+	if err != nil {
+		log.Printf("Manifest reload failed, keeping the old ones: %s", err)
+		return nil, err
+	}
+
+	s.current.Store(c)
+
+	total := 0
+	for _, manifest := range c.Manifests {
+		total += len(manifest.Keys)
+	}
+	log.Printf("Manifests reloaded, %d clips in %d categories", total, len(c.Categories.Categories))
+
+	return c, nil
+}
+
+// modTimes is the last modified time of every file a reload would read.
+func (s *Source) modTimes() map[string]time.Time {
+	files := []string{CategoriesFile(s.dir)}
+	if s.categoriesFile != "" {
+		files = []string{s.categoriesFile}
+	}
+
+	for _, difficulty := range []types.Difficulty{types.Easy, types.Medium, types.Hard, types.Legend} {
+		files = append(files, ManifestFile(s.dir, difficulty))
+	}
+
+	times := make(map[string]time.Time, len(files))
+	for _, file := range files {
+		if info, err := os.Stat(file); err == nil {
+			times[file] = info.ModTime()
+		}
+	}
+
+	return times
+}
+
+// Watch polls the manifest files and reloads when any of them change, until
+// the process exits.
+func (s *Source) Watch(interval time.Duration) {
+	last := s.modTimes()
+
+	for {
+		time.Sleep(interval)
+
+		times := s.modTimes()
+		changed := len(times) != len(last)
+		for file, t := range times {
+			if !last[file].Equal(t) {
+				changed = true
+			}
+		}
+
+		if !changed {
+			continue
+		}
+
+		// a failed reload is logged, and a half-written file will change
+		// again when it's finished
+		s.Reload()
+		last = times
+	}
+}
@@ -0,0 +1,178 @@
+package catalog
+
+import (
+	"backend/types"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+type ProblemKind string
+
+const (
+	MissingFile     ProblemKind = "missing-file"     // in a manifest, not in the clip directory
+	OrphanedFile    ProblemKind = "orphaned-file"    // in the clip directory, not in any manifest
+	ZeroByteFile    ProblemKind = "zero-byte-file"   // the clip file is empty
+	DuplicateClip   ProblemKind = "duplicate-clip"   // listed more than once across episodes or difficulties
+	EmptyEpisode    ProblemKind = "empty-episode"    // a category with no clips in a difficulty
+	UnknownCategory ProblemKind = "unknown-category" // a manifest key that isn't in the registry
+	BadManifest     ProblemKind = "bad-manifest"     // couldn't read or parse a file at all
+	BadClipDir      ProblemKind = "bad-clip-dir"     // couldn't list the clip directory
+)
+
+type Problem struct {
+	Kind       ProblemKind      `json:"kind"`
+	Difficulty types.Difficulty `json:"difficulty,omitempty"`
+	Episode    types.Episode    `json:"episode,omitempty"`
+	Clip       string           `json:"clip,omitempty"`
+	Message    string           `json:"message"`
+}
+
+func (p Problem) String() string {
+	return fmt.Sprintf("%s: %s", p.Kind, p.Message)
+}
+
+type Report struct {
+	Clips    int       `json:"clips"`
+	Problems []Problem `json:"problems"`
+}
+
+func (r *Report) add(p Problem) {
+	r.Problems = append(r.Problems, p)
+}
+
+// where a clip was listed
+type listing struct {
+	difficulty types.Difficulty
+	episode    types.Episode
+}
+
+// Validate checks every manifest in dir against each other and against the
+// files in clipDir. Unlike Load it doesn't stop at the first problem.
+func Validate(dir, categoriesFile, clipDir string) Report {
+	report := Report{Problems: make([]Problem, 0)}
+
+	categories, err := LoadCategories(dir, categoriesFile)
+	if err != nil {
+		report.add(Problem{Kind: BadManifest, Message: fmt.Sprintf("failed to load categories: %s", err)})
+		return report
+	}
+
+	listings := make(map[string][]listing)
+	clips := make([]string, 0)
+
+	for _, difficulty := range []types.Difficulty{types.Easy, types.Medium, types.Hard, types.Legend} {
+		manifest, err := LoadManifest(dir, difficulty)
+		if err != nil {
+			report.add(Problem{Kind: BadManifest, Difficulty: difficulty, Message: err.Error()})
+			continue
+		}
+
+		unknown := make([]string, 0)
+		for episode := range manifest {
+			if _, ok := categories.Get(episode); !ok {
+				unknown = append(unknown, string(episode))
+			}
+		}
+		sort.Strings(unknown)
+
+		for _, episode := range unknown {
+			report.add(Problem{
+				Kind:       UnknownCategory,
+				Difficulty: difficulty,
+				Episode:    types.Episode(episode),
+				Message:    fmt.Sprintf("%s has clips for unknown category '%s'", difficulty, episode),
+			})
+		}
+
+		// unknown categories still get their clips checked
+		episodes := categories.Ids()
+		for _, episode := range unknown {
+			episodes = append(episodes, types.Episode(episode))
+		}
+
+		for _, episode := range episodes {
+			if _, known := categories.Get(episode); known && len(manifest[episode]) == 0 {
+				report.add(Problem{
+					Kind:       EmptyEpisode,
+					Difficulty: difficulty,
+					Episode:    episode,
+					Message:    fmt.Sprintf("%s has no clips for '%s'", difficulty, episode),
+				})
+			}
+
+			for _, clip := range manifest[episode] {
+				if _, seen := listings[clip]; !seen {
+					clips = append(clips, clip)
+				}
+				listings[clip] = append(listings[clip], listing{difficulty, episode})
+			}
+		}
+	}
+
+	report.Clips = len(clips)
+
+	for _, clip := range clips {
+		if len(listings[clip]) > 1 {
+			places := make([]string, len(listings[clip]))
+			for i, l := range listings[clip] {
+				places[i] = fmt.Sprintf("%s/%s", l.difficulty, l.episode)
+			}
+
+			report.add(Problem{
+				Kind:       DuplicateClip,
+				Difficulty: listings[clip][0].difficulty,
+				Episode:    listings[clip][0].episode,
+				Clip:       clip,
+				Message:    fmt.Sprintf("%s is listed %d times: %s", clip, len(places), strings.Join(places, ", ")),
+			})
+		}
+
+		first := listings[clip][0]
+		info, err := os.Stat(filepath.Join(clipDir, clip) + ".enc")
+		switch {
+		case err != nil:
+			report.add(Problem{
+				Kind:       MissingFile,
+				Difficulty: first.difficulty,
+				Episode:    first.episode,
+				Clip:       clip,
+				Message:    fmt.Sprintf("%s/%s clip %s has no file: %s", first.difficulty, first.episode, clip, err),
+			})
+		case info.Size() == 0:
+			report.add(Problem{
+				Kind:       ZeroByteFile,
+				Difficulty: first.difficulty,
+				Episode:    first.episode,
+				Clip:       clip,
+				Message:    fmt.Sprintf("%s/%s clip %s is empty", first.difficulty, first.episode, clip),
+			})
+		}
+	}
+
+	files, err := ioutil.ReadDir(clipDir)
+	if err != nil {
+		report.add(Problem{Kind: BadClipDir, Message: fmt.Sprintf("failed to list clip directory: %s", err)})
+		return report
+	}
+
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), ".enc") {
+			continue
+		}
+
+		clip := strings.TrimSuffix(file.Name(), ".enc")
+		if _, listed := listings[clip]; !listed {
+			report.add(Problem{
+				Kind:    OrphanedFile,
+				Clip:    clip,
+				Message: fmt.Sprintf("%s isn't in any manifest", file.Name()),
+			})
+		}
+	}
+
+	return report
+}
@@ -8,6 +8,8 @@ require (
 	github.com/google/uuid v1.2.0
 	github.com/gorilla/handlers v1.5.1
 	github.com/gorilla/mux v1.8.0
+	github.com/lib/pq v1.10.9
 	github.com/mattn/go-sqlite3 v1.14.7
 	github.com/rs/cors v1.8.0
+	golang.org/x/text v0.13.0
 )
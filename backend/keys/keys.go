@@ -0,0 +1,488 @@
+package keys
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"backend/types"
+)
+
+// Key is one signing + encryption key pair. Tokens carry the Id in their
+// `kid` header so we know which pair to verify and decrypt them with.
+type Key struct {
+	Id            string
+	SignatureKey  []byte
+	EncryptionKey [32]byte
+	Created       time.Time
+	Retired       *time.Time // nil while the key is the active signing key
+}
+
+// on-disk representation of a Key
+type keyFileEntry struct {
+	Id            string     `json:"id"`
+	SignatureKey  string     `json:"signatureKey"`
+	EncryptionKey string     `json:"encryptionKey"`
+	Created       time.Time  `json:"created"`
+	Retired       *time.Time `json:"retired,omitempty"`
+}
+
+// how often a ring loaded from a file checks it for rotations made by other
+// replicas, and how soon after reloading an unknown kid can reload it again
+const (
+	RELOAD_INTERVAL = time.Minute
+	RELOAD_COOLDOWN = time.Second * 5
+)
+
+// a lock on the key file older than this was left by a replica that died
+// while rotating
+const STALE_LOCK = time.Minute
+
+// Ring is every key tokens can be checked with. Replicas that share a key
+// file share a ring: whichever one finds the active key due rotates it under
+// a lock on the file, and the rest pick the new key up when they reload, or
+// straight away when a token signed with it turns up.
+type Ring struct {
+	lock sync.RWMutex
+
+	// keys[0] is the active key, everything after it is retired and only
+	// kept around to verify tokens until the grace period runs out
+	keys []Key
+
+	file        string
+	gracePeriod time.Duration
+	lastReload  time.Time
+}
+
+// NewRing makes an empty ring. Retired keys are accepted for gracePeriod,
+// which should be at least types.TOKEN_LIFETIME or rotating will kill runs.
+func NewRing(gracePeriod time.Duration) *Ring {
+	if gracePeriod < types.TOKEN_LIFETIME {
+		log.Printf("key grace period %s is shorter than the token lifetime, using %s", gracePeriod, types.TOKEN_LIFETIME)
+		gracePeriod = types.TOKEN_LIFETIME
+	}
+
+	return &Ring{gracePeriod: gracePeriod}
+}
+
+func newKey() (Key, error) {
+	key := Key{Created: time.Now().UTC()}
+
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return Key{}, fmt.Errorf("failed to generate key id: %w", err)
+	}
+	key.Id = hex.EncodeToString(id)
+
+	key.SignatureKey = make([]byte, types.SIGNATURE_LENGTH)
+	if _, err := rand.Read(key.SignatureKey); err != nil {
+		return Key{}, fmt.Errorf("failed to generate signature key: %w", err)
+	}
+
+	if _, err := rand.Read(key.EncryptionKey[:]); err != nil {
+		return Key{}, fmt.Errorf("failed to generate encryption key: %w", err)
+	}
+
+	return key, nil
+}
+
+func decodeKeys(id, signature, encryption string) (Key, error) {
+	key := Key{Id: id, Created: time.Now().UTC()}
+
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return Key{}, fmt.Errorf("key %s: signature key is not base64: %w", id, err)
+	}
+
+	if len(sig) < types.SIGNATURE_LENGTH {
+		return Key{}, fmt.Errorf("key %s: signature key must be at least %d bytes", id, types.SIGNATURE_LENGTH)
+	}
+	key.SignatureKey = sig
+
+	enc, err := base64.StdEncoding.DecodeString(encryption)
+	if err != nil {
+		return Key{}, fmt.Errorf("key %s: encryption key is not base64: %w", id, err)
+	}
+
+	if len(enc) != len(key.EncryptionKey) {
+		return Key{}, fmt.Errorf("key %s: encryption key must be exactly %d bytes", id, len(key.EncryptionKey))
+	}
+	copy(key.EncryptionKey[:], enc)
+
+	return key, nil
+}
+
+// LoadEnv uses fixed key pairs supplied as comma separated lists of base64
+// strings, usually from the environment. The nth signature key goes with the
+// nth encryption key. The first pair signs, the rest are only used to check
+// tokens, so to rotate by hand put a new pair at the front and drop the last
+// one once its tokens have expired. Keys loaded this way can't be persisted,
+// so they won't be rotated automatically.
+func (r *Ring) LoadEnv(signatures, encryptions string) error {
+	sigs := strings.Split(signatures, ",")
+	encs := strings.Split(encryptions, ",")
+	if len(sigs) != len(encs) {
+		return fmt.Errorf("got %d signature keys but %d encryption keys", len(sigs), len(encs))
+	}
+
+	keys := make([]Key, 0, len(sigs))
+	for i := range sigs {
+		signature := strings.TrimSpace(sigs[i])
+
+		// named after the key itself so every replica agrees on the ids
+		// whatever order they're listed in
+		hash := sha256.Sum256([]byte(signature))
+		key, err := decodeKeys("env-"+hex.EncodeToString(hash[:4]), signature, strings.TrimSpace(encs[i]))
+		if err != nil {
+			return err
+		}
+
+		keys = append(keys, key)
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.keys = keys
+	r.file = ""
+
+	return nil
+}
+
+// LoadFile reads the ring from a JSON key file, creating the file with a
+// fresh key if it doesn't exist yet. Rotations are written back to it.
+func (r *Ring) LoadFile(file string) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.file = file
+
+	_, err := os.Stat(file)
+	if os.IsNotExist(err) {
+		log.Printf("Key file `%s` not found, creating...", file)
+
+		key, err := newKey()
+		if err != nil {
+			return err
+		}
+
+		r.keys = []Key{key}
+		r.lastReload = time.Now()
+		return r.save()
+	}
+
+	return r.reload()
+}
+
+// reload reads r.file back in, for rotations made by other replicas. Caller
+// must hold the write lock.
+func (r *Ring) reload() error {
+	r.lastReload = time.Now()
+
+	bytes, err := ioutil.ReadFile(r.file)
+	if err != nil {
+		return fmt.Errorf("failed to read key file: %w", err)
+	}
+
+	var entries []keyFileEntry
+	if err = json.Unmarshal(bytes, &entries); err != nil {
+		return fmt.Errorf("failed to parse key file: %w", err)
+	}
+
+	if len(entries) == 0 {
+		return fmt.Errorf("key file `%s` has no keys in it", r.file)
+	}
+
+	keys := make([]Key, 0, len(entries))
+	for _, entry := range entries {
+		key, err := decodeKeys(entry.Id, entry.SignatureKey, entry.EncryptionKey)
+		if err != nil {
+			return err
+		}
+		key.Created = entry.Created
+		key.Retired = entry.Retired
+		keys = append(keys, key)
+	}
+
+	if keys[0].Retired != nil {
+		return fmt.Errorf("first key in `%s` is retired, it must be the active key", r.file)
+	}
+
+	r.keys = keys
+	r.prune()
+	return nil
+}
+
+// Reload reads the key file back in, if there is one.
+func (r *Ring) Reload() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.file == "" {
+		return nil
+	}
+
+	return r.reload()
+}
+
+// Generate gives the ring a single random key that only lives in memory.
+// Every token is invalidated on restart, so this is only useful for dev.
+func (r *Ring) Generate() error {
+	key, err := newKey()
+	if err != nil {
+		return err
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.keys = []Key{key}
+	r.file = ""
+
+	return nil
+}
+
+// save writes the ring out to r.file. Caller must hold the write lock.
+func (r *Ring) save() error {
+	if r.file == "" {
+		return nil
+	}
+
+	entries := make([]keyFileEntry, len(r.keys))
+	for i, key := range r.keys {
+		entries[i] = keyFileEntry{
+			Id:            key.Id,
+			SignatureKey:  base64.StdEncoding.EncodeToString(key.SignatureKey),
+			EncryptionKey: base64.StdEncoding.EncodeToString(key.EncryptionKey[:]),
+			Created:       key.Created,
+			Retired:       key.Retired,
+		}
+	}
+
+	bytes, err := json.MarshalIndent(entries, "", "\t")
+	if err != nil {
+		return fmt.Errorf("failed to marshal keys: %w", err)
+	}
+
+	// write then rename so a crash can't leave us with half a key file
+	tmp, err := ioutil.TempFile(filepath.Dir(r.file), ".keys-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp key file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if err = tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to chmod temp key file: %w", err)
+	}
+
+	if _, err = tmp.Write(bytes); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write temp key file: %w", err)
+	}
+
+	if err = tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close temp key file: %w", err)
+	}
+
+	if err = os.Rename(tmp.Name(), r.file); err != nil {
+		return fmt.Errorf("failed to replace key file: %w", err)
+	}
+
+	return nil
+}
+
+// prune drops retired keys whose grace period is over. Caller must hold the
+// write lock.
+func (r *Ring) prune() {
+	kept := r.keys[:0]
+	for _, key := range r.keys {
+		if key.Retired == nil || time.Since(*key.Retired) <= r.gracePeriod {
+			kept = append(kept, key)
+		}
+	}
+	r.keys = kept
+}
+
+// Current returns the key new tokens should be signed with.
+func (r *Ring) Current() Key {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	return r.keys[0]
+}
+
+// Lookup finds the key with the given id, as long as it's active or still
+// inside its grace period. An id it's never heard of might be a key another
+// replica has just rotated in, so the key file is checked for it.
+func (r *Ring) Lookup(id string) (Key, bool) {
+	if key, ok := r.lookup(id); ok {
+		return key, true
+	}
+
+	r.lock.Lock()
+	reloaded := false
+	if r.file != "" && time.Since(r.lastReload) > RELOAD_COOLDOWN {
+		if err := r.reload(); err != nil {
+			log.Printf("failed to reload keys: %s", err)
+		}
+		reloaded = true
+	}
+	r.lock.Unlock()
+
+	if !reloaded {
+		return Key{}, false
+	}
+
+	return r.lookup(id)
+}
+
+func (r *Ring) lookup(id string) (Key, bool) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	for _, key := range r.keys {
+		if key.Id != id {
+			continue
+		}
+
+		if key.Retired != nil && time.Since(*key.Retired) > r.gracePeriod {
+			return Key{}, false
+		}
+
+		return key, true
+	}
+
+	return Key{}, false
+}
+
+// CanRotate reports whether rotations can be kept across restarts.
+func (r *Ring) CanRotate() bool {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	return r.file != ""
+}
+
+// lockFile stops replicas sharing a key file from rotating it at the same
+// time. The returned func releases the lock.
+func lockFile(file string) (func(), error) {
+	lock := file + ".lock"
+
+	for attempt := 0; attempt < 2; attempt++ {
+		f, err := os.OpenFile(lock, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err == nil {
+			f.Close()
+			return func() { os.Remove(lock) }, nil
+		}
+
+		if !os.IsExist(err) {
+			return nil, fmt.Errorf("failed to lock key file: %w", err)
+		}
+
+		info, err := os.Stat(lock)
+		if err != nil || time.Since(info.ModTime()) < STALE_LOCK {
+			return nil, fmt.Errorf("key file is locked by another replica")
+		}
+
+		log.Printf("removing stale key file lock `%s`", lock)
+		os.Remove(lock)
+	}
+
+	return nil, fmt.Errorf("key file is locked by another replica")
+}
+
+// rotateIfDue rotates the ring if its active key is older than interval.
+// With a key file it holds the file's lock and rereads it first, so if
+// another replica already rotated this one just picks up its key.
+func (r *Ring) rotateIfDue(interval time.Duration) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.file != "" {
+		unlock, err := lockFile(r.file)
+		if err != nil {
+			return err
+		}
+		defer unlock()
+
+		if err = r.reload(); err != nil {
+			return err
+		}
+	}
+
+	if time.Since(r.keys[0].Created) < interval {
+		return nil
+	}
+
+	return r.rotate()
+}
+
+// Rotate makes a fresh active key and retires the current one. Replicas
+// sharing a key file should use RotateEvery instead, which takes turns.
+func (r *Ring) Rotate() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	return r.rotate()
+}
+
+// rotate does Rotate, the caller holds the write lock.
+func (r *Ring) rotate() error {
+	key, err := newKey()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	if len(r.keys) > 0 {
+		r.keys[0].Retired = &now
+	}
+
+	r.keys = append([]Key{key}, r.keys...)
+	r.prune()
+
+	if err = r.save(); err != nil {
+		return err
+	}
+
+	log.Printf("Rotated signing key, active key is now %s", key.Id)
+	return nil
+}
+
+// RotateEvery rotates the ring on a schedule until the process exits. The
+// schedule is based on the active key's age so restarts don't reset it, and
+// every replica sharing the key file agrees on it. In between it rereads the
+// file so rotations made by other replicas are picked up.
+func (r *Ring) RotateEvery(interval time.Duration) {
+	for {
+		wait := interval - time.Since(r.Current().Created)
+		if wait > RELOAD_INTERVAL {
+			time.Sleep(RELOAD_INTERVAL)
+			if err := r.Reload(); err != nil {
+				log.Printf("failed to reload keys: %s", err)
+			}
+			continue
+		}
+
+		if wait > 0 {
+			time.Sleep(wait)
+		}
+
+		if err := r.rotateIfDue(interval); err != nil {
+			log.Printf("failed to rotate keys: %s", err)
+			// try again later, old key is still good
+			time.Sleep(time.Minute)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package keys
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSharedFileRotation(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "keys.json")
+
+	first := NewRing(time.Hour)
+	if err := first.LoadFile(file); err != nil {
+		t.Fatal(err)
+	}
+
+	second := NewRing(time.Hour)
+	if err := second.LoadFile(file); err != nil {
+		t.Fatal(err)
+	}
+
+	old := first.Current().Id
+	if second.Current().Id != old {
+		t.Fatalf("replicas loaded different keys: %s and %s", old, second.Current().Id)
+	}
+
+	if err := first.rotateIfDue(0); err != nil {
+		t.Fatal(err)
+	}
+
+	rotated := first.Current().Id
+	if rotated == old {
+		t.Fatal("rotating didn't change the active key")
+	}
+
+	// a token signed with the new key turns up at the other replica
+	second.lastReload = time.Time{}
+	if _, ok := second.Lookup(rotated); !ok {
+		t.Fatal("other replica doesn't know the rotated key")
+	}
+
+	// the other replica's schedule comes due too, but it's already done
+	if err := second.rotateIfDue(time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	if second.Current().Id != rotated {
+		t.Fatalf("other replica rotated again to %s", second.Current().Id)
+	}
+
+	if _, ok := second.Lookup(old); !ok {
+		t.Fatal("old key should still be inside its grace period")
+	}
+}
+
+func TestRotationLock(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "keys.json")
+
+	unlock, err := lockFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = lockFile(file); err == nil {
+		t.Fatal("took the lock twice")
+	}
+
+	unlock()
+
+	unlock, err = lockFile(file)
+	if err != nil {
+		t.Fatalf("couldn't take the lock after it was released: %s", err)
+	}
+	unlock()
+}
+
+func randomBase64(t *testing.T, n int) string {
+	bytes := make([]byte, n)
+	if _, err := rand.Read(bytes); err != nil {
+		t.Fatal(err)
+	}
+	return base64.StdEncoding.EncodeToString(bytes)
+}
+
+func TestLoadEnvList(t *testing.T) {
+	sigA, sigB := randomBase64(t, 64), randomBase64(t, 64)
+	encA, encB := randomBase64(t, 32), randomBase64(t, 32)
+
+	ring := NewRing(time.Hour)
+	if err := ring.LoadEnv(sigA+","+sigB, encA+", "+encB); err != nil {
+		t.Fatal(err)
+	}
+
+	active := ring.Current()
+	if base64.StdEncoding.EncodeToString(active.SignatureKey) != sigA {
+		t.Fatal("the first key should be the active one")
+	}
+
+	// the same keys listed the other way round keep their ids
+	swapped := NewRing(time.Hour)
+	if err := swapped.LoadEnv(sigB+","+sigA, encB+","+encA); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := swapped.Lookup(active.Id); !ok {
+		t.Fatal("key ids should come from the keys, not their order")
+	}
+
+	if err := ring.LoadEnv(sigA+","+sigB, encA); err == nil {
+		t.Fatal("mismatched lists should be refused")
+	}
+}
@@ -2,127 +2,361 @@ package main
 
 import (
 	"backend/api"
+	"backend/catalog"
+	"backend/keys"
+	"backend/names"
+	"backend/ratelimit"
+	"backend/storage"
 	"backend/types"
-	"encoding/json"
+	"crypto/rand"
 	"fmt"
-	"io/ioutil"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
-	"path"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/gorilla/handlers"
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/rs/cors"
 )
 
-func LoadManifests(manifestPath string) map[types.Difficulty]types.RandomManifest {
-	log.Printf("Manifest Dir: %s", manifestPath)
+const PREFIX = "/clipquiz/v1/"
 
-	manifests := make(map[types.Difficulty]types.RandomManifest, 6)
+func manifestPathFromEnv() string {
+	manifestPath := os.Getenv("MANIFEST_FILE_LOCATION")
+	if manifestPath == "" {
+		manifestPath = "."
+	}
+	return manifestPath
+}
 
-	for _, difficulty := range []types.Difficulty{types.Easy, types.Medium, types.Hard, types.Legend} {
-		fullPath := path.Join(manifestPath, fmt.Sprintf("%s.json", string(difficulty)))
-		jsonFile, err := os.Open(fullPath)
-		if err != nil {
-			log.Panicf("failed to open '%s': %s", fullPath, err)
+// DATABASE_URL picks the backend, see storage.Open. DATABASE_FILE is the
+// older way of pointing at a sqlite file.
+func databaseFromEnv() string {
+	dbDsn := os.Getenv("DATABASE_URL")
+	if dbDsn == "" {
+		dbDsn = os.Getenv("DATABASE_FILE")
+	}
+	if dbDsn == "" {
+		dbDsn = "highscores.db"
+	}
+	return dbDsn
+}
+
+func main() {
+	// subcommands, anything else starts the server
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "calibrate":
+			os.Exit(calibrateCommand(os.Args[2:]))
+		case "validate":
+			os.Exit(validateCommand(os.Args[2:]))
+		case "redirect-layout":
+			os.Exit(redirectLayoutCommand(os.Args[2:]))
+		default:
+			fmt.Fprintf(os.Stderr, "unknown command %q, commands are: calibrate, validate, redirect-layout\n", os.Args[1])
+			os.Exit(2)
 		}
-		defer jsonFile.Close()
+	}
 
-		bytes, err := ioutil.ReadAll(jsonFile)
-		if err != nil {
-			log.Panicf("failed to read '%s': %s", fullPath, err)
+	log.Print("Hello There")
+
+	// READ CONFIGURATION
+	manifestPath := manifestPathFromEnv()
+
+	clipDir := os.Getenv("CLIP_DIRECTORY")
+
+	dbDsn := databaseFromEnv()
+
+	// don't print database passwords
+	dbDsnDisplay := dbDsn
+	if dbUrl, err := url.Parse(dbDsn); err == nil && dbUrl.User != nil {
+		dbDsnDisplay = dbUrl.Redacted()
+	}
+
+	frontendOrigin := os.Getenv("BACKEND_FRONTEND_ALLOWED_ORIGIN")
+	if frontendOrigin == "" {
+		frontendOrigin = "http://localhost:8000"
+	}
+
+	keyFile := os.Getenv("KEY_FILE")
+
+	var rotationInterval time.Duration
+	if interval := os.Getenv("KEY_ROTATION_INTERVAL"); interval != "" {
+		var err error
+		if rotationInterval, err = time.ParseDuration(interval); err != nil {
+			log.Panicf("failed to parse KEY_ROTATION_INTERVAL: %s", err)
 		}
+	}
 
-		var manifest types.Manifest
-		err = json.Unmarshal(bytes, &manifest)
+	gracePeriod := types.TOKEN_LIFETIME
+	if grace := os.Getenv("KEY_GRACE_PERIOD"); grace != "" {
+		var err error
+		if gracePeriod, err = time.ParseDuration(grace); err != nil {
+			log.Panicf("failed to parse KEY_GRACE_PERIOD: %s", err)
+		}
+	}
 
-		if err != nil {
-			log.Panicf("failed to parse '%s': %s", fullPath, err)
+	var manifestWatchInterval time.Duration
+	if interval := os.Getenv("MANIFEST_WATCH_INTERVAL"); interval != "" {
+		var err error
+		if manifestWatchInterval, err = time.ParseDuration(interval); err != nil {
+			log.Panicf("failed to parse MANIFEST_WATCH_INTERVAL: %s", err)
 		}
+	}
 
-		var rm types.RandomManifest
-		totalSize := manifest.TotalSize()
+	var noBloomFilter = false
+	if os.Getenv("NO_BLOOM_FILTER") != "" {
+		noBloomFilter = true
+	}
 
-		rm.Lookup = make(map[string]types.Episode, totalSize)
-		rm.Keys = make([]string, totalSize)
+	// the daily challenge's clips come from this, so keep it secret and keep it
+	// the same across restarts and replicas
+	var dailySecret []byte
+	if secret := os.Getenv("DAILY_SECRET"); secret != "" {
+		dailySecret = []byte(secret)
+	} else {
+		log.Print("No DAILY_SECRET set, daily mode is disabled")
+	}
 
-		total := 0
-		for i := range manifest.PhantomMenace {
-			rm.Lookup[manifest.PhantomMenace[i]] = types.PhantomMenace
-			rm.Keys[total] = manifest.PhantomMenace[i]
-			total++
+	// challenge codes are signed with this, keep it the same across restarts
+	// and replicas or shared codes stop working
+	challengeSecret := []byte(os.Getenv("CHALLENGE_SECRET"))
+	if len(challengeSecret) == 0 {
+		log.Print("No CHALLENGE_SECRET set, using a random one. Challenge codes won't survive a restart!")
+		challengeSecret = make([]byte, 32)
+		if _, err := rand.Read(challengeSecret); err != nil {
+			log.Panicf("failed to make challenge secret: %s", err)
 		}
+	}
 
-		for i := range manifest.AttackClones {
-			rm.Lookup[manifest.AttackClones[i]] = types.AttackClones
-			rm.Keys[total] = manifest.AttackClones[i]
-			total++
+	curve, err := api.ParseCurve(os.Getenv("SCORING_CURVE"))
+	if err != nil {
+		log.Panicf("failed to parse SCORING_CURVE: %s", err)
+	}
+
+	// what guessing the wrong film from the right trilogy gets you, partial or
+	// save. By default it's just wrong.
+	nearMiss, err := api.ParseNearMissRule(os.Getenv("NEAR_MISS"))
+	if err != nil {
+		log.Panicf("failed to parse NEAR_MISS: %s", err)
+	}
+
+	// e.g. `bits=16,max=24`, makes starting a run cost a proof of work
+	pow, err := api.ParseProofOfWork(os.Getenv("POW"))
+	if err != nil {
+		log.Panicf("failed to parse POW: %s", err)
+	}
+
+	// e.g. `lives=points,blitz=points`, everything else sorts by score
+	boardSort, err := storage.ParseBoardSort(os.Getenv("LEADERBOARD_SORT"))
+	if err != nil {
+		log.Panicf("failed to parse LEADERBOARD_SORT: %s", err)
+	}
+
+	// clips are also handed out as signed links, which can be put behind a
+	// CDN with CLIP_URL_BASE or sent on to an object store with
+	// CLIP_REDIRECT_URL, laid out with `redirect-layout` and fronted by
+	// something that opens the one-off redirect tokens with the same
+	// CLIP_REDIRECT_SECRET, see api.RedirectObject
+	links := api.ClipLinks{
+		Base:           os.Getenv("CLIP_URL_BASE"),
+		Redirect:       os.Getenv("CLIP_REDIRECT_URL"),
+		RedirectSecret: []byte(os.Getenv("CLIP_REDIRECT_SECRET")),
+		Lifetime:       types.CLIP_URL_LIFETIME,
+	}
+	if links.Redirect != "" && len(links.RedirectSecret) == 0 {
+		log.Panic("CLIP_REDIRECT_URL needs a CLIP_REDIRECT_SECRET to find clips under")
+	}
+	if lifetime := os.Getenv("CLIP_URL_LIFETIME"); lifetime != "" {
+		if links.Lifetime, err = time.ParseDuration(lifetime); err != nil {
+			log.Panicf("failed to parse CLIP_URL_LIFETIME: %s", err)
 		}
+	}
 
-		for i := range manifest.RevengeSith {
-			rm.Lookup[manifest.RevengeSith[i]] = types.RevengeSith
-			rm.Keys[total] = manifest.RevengeSith[i]
-			total++
+	// encrypt clips again on every serve, from /clip, clip links and the daily
+	// recap. The frontend takes it off with the Clip-Key header
+	var wrapClips = false
+	if os.Getenv("WRAP_CLIPS") != "" {
+		wrapClips = true
+	}
+	if wrapClips && links.Redirect != "" {
+		log.Panic("WRAP_CLIPS can't wrap clips that CLIP_REDIRECT_URL serves, use one or the other")
+	}
+
+	// e.g. `/clipquiz/v1/clip=30/1m:10,GET /clipquiz/v1/highscore=off`, on top
+	// of ratelimit.DefaultPolicies. `off` turns them all off.
+	limitPolicies, err := ratelimit.ParsePolicies(os.Getenv("RATE_LIMITS"), ratelimit.DefaultPolicies)
+	if err != nil {
+		log.Panicf("failed to parse RATE_LIMITS: %s", err)
+	}
+
+	// the same, but for each run instead of each address, on top of
+	// ratelimit.DefaultSessionPolicies
+	sessionPolicies, err := ratelimit.ParsePolicies(os.Getenv("SESSION_RATE_LIMITS"), ratelimit.DefaultSessionPolicies)
+	if err != nil {
+		log.Panicf("failed to parse SESSION_RATE_LIMITS: %s", err)
+	}
+
+	// the proxies in front of us, X-Forwarded-For is ignored from anyone else
+	trustedProxies, err := ratelimit.ParseProxies(os.Getenv("TRUSTED_PROXIES"))
+	if err != nil {
+		log.Panicf("failed to parse TRUSTED_PROXIES: %s", err)
+	}
+
+	// `on`, or e.g. `fast=1s,odds=0.0001`, holds suspicious scores back from
+	// the boards for an admin to look at
+	integrity, err := api.ParseIntegrity(os.Getenv("INTEGRITY"))
+	if err != nil {
+		log.Panicf("failed to parse INTEGRITY: %s", err)
+	}
+
+	// one entry a line, replaces names.DefaultBlocklist
+	blocklist := names.DefaultBlocklist
+	blocklistFile := os.Getenv("NAME_BLOCKLIST_FILE")
+	if blocklistFile != "" {
+		if blocklist, err = names.LoadList(blocklistFile); err != nil {
+			log.Panicf("failed to load NAME_BLOCKLIST_FILE: %s", err)
 		}
+	} else {
+		blocklistFile = "default"
+	}
 
-		for i := range manifest.NewHope {
-			rm.Lookup[manifest.NewHope[i]] = types.NewHope
-			rm.Keys[total] = manifest.NewHope[i]
-			total++
+	// comma separated, on top of names.DefaultReserved
+	reserved := names.DefaultReserved
+	if value := os.Getenv("NAME_RESERVED"); value != "" {
+		for _, name := range strings.Split(value, ",") {
+			reserved = append(reserved, strings.TrimSpace(name))
 		}
+	}
+
+	namePolicy := names.NewPolicy(blocklist, reserved)
 
-		for i := range manifest.Empire {
-			rm.Lookup[manifest.Empire[i]] = types.Empire
-			rm.Keys[total] = manifest.Empire[i]
-			total++
+	adminToken := os.Getenv("ADMIN_TOKEN")
+	if adminToken == "" {
+		log.Print("No ADMIN_TOKEN set, admin endpoints are disabled")
+		if integrity.Enabled {
+			log.Print("INTEGRITY is on without an ADMIN_TOKEN, flagged scores can't be approved")
 		}
+	}
 
-		for i := range manifest.Rotj {
-			rm.Lookup[manifest.Rotj[i]] = types.Rotj
-			rm.Keys[total] = manifest.Rotj[i]
-			total++
+	fmt.Printf("Configuration:\n\tManifest Path = '%s'\n\tManifest Watch = '%s'\n\tClip Dir = '%s'\n\tDB = '%s'\n\tFrontend Origin = '%s'\n\tKey File = '%s'\n\tKey Rotation = '%s'\n\tKey Grace Period = '%s'\n\tBloom Filter = %t\n\tScoring Curve = '%s'\n\tNear Miss = '%s'\n\tProof of Work = '%s'\n\tIntegrity = '%s'\n\tClip Links = '%s' for %s\n\tWrap Clips = %t\n\tRate Limits = %v\n\tSession Rate Limits = %v\n\tTrusted Proxies = %v\n\tName Blocklist = '%s' (%d entries)\n\tReserved Names = %d\n", manifestPath, manifestWatchInterval, clipDir, dbDsnDisplay, frontendOrigin, keyFile, rotationInterval, gracePeriod, !noBloomFilter, curve, nearMiss, pow, integrity, links.Base+api.MEDIA_PATH, links.Lifetime, wrapClips, limitPolicies, sessionPolicies, trustedProxies, blocklistFile, len(blocklist), len(reserved))
+
+	// dry run: say what the migrations would do and stop there
+	if os.Getenv("MIGRATE_DRY_RUN") != "" {
+		version, pending, err := storage.PlanMigrations(dbDsn)
+		if err != nil {
+			log.Fatalf("failed to plan migrations: %s", err)
 		}
 
-		if total != totalSize || len(rm.Keys) != len(rm.Lookup) {
-			log.Panic("AAAHHHH")
+		log.Printf("Database schema is at version %d, %d migration(s) pending", version, len(pending))
+		for _, m := range pending {
+			log.Printf("Would apply %04d_%s:\n%s", m.Version, m.Name, m.SQL)
 		}
+		return
+	}
 
-		manifests[difficulty] = rm
+	// get the manifest files
+	log.Printf("Manifest Dir: %s", manifestPath)
+	manifests, err := catalog.NewSource(manifestPath, os.Getenv("CATEGORIES_FILE"), clipDir)
+	if err != nil {
+		log.Panicf("failed to load manifests: %s", err)
 	}
 
-	return manifests
-}
+	// reload them on SIGHUP, and optionally whenever they change
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	go func() {
+		for range hangups {
+			log.Print("Got SIGHUP, reloading manifests")
+			manifests.Reload()
+		}
+	}()
 
-const PREFIX = "/clipquiz/v1/"
+	if manifestWatchInterval > 0 {
+		go manifests.Watch(manifestWatchInterval)
+	}
 
-func main() {
-	log.Print("Hello There")
+	// get the keys, in order of preference: key file, environment, random.
+	// SIGNATURE_KEY and ENCRYPTION_KEY can be comma separated lists, the first
+	// pair signs. Replicas can share one KEY_FILE and take turns rotating it.
+	keyRing := keys.NewRing(gracePeriod)
+	if keyFile != "" {
+		if err := keyRing.LoadFile(keyFile); err != nil {
+			log.Panicf("failed to load keys: %s", err)
+		}
+	} else if os.Getenv("SIGNATURE_KEY") != "" || os.Getenv("ENCRYPTION_KEY") != "" {
+		if err := keyRing.LoadEnv(os.Getenv("SIGNATURE_KEY"), os.Getenv("ENCRYPTION_KEY")); err != nil {
+			log.Panicf("failed to load keys: %s", err)
+		}
+	} else {
+		log.Print("No KEY_FILE or SIGNATURE_KEY/ENCRYPTION_KEY set, using random keys. Tokens won't survive a restart!")
+		if err := keyRing.Generate(); err != nil {
+			log.Panicf("failed to generate keys: %s", err)
+		}
+	}
 
-	// READ CONFIGURATION
-	manifestPath := os.Getenv("MANIFEST_FILE_LOCATION")
-	if manifestPath == "" {
-		manifestPath = "."
+	if rotationInterval > 0 {
+		if keyRing.CanRotate() {
+			go keyRing.RotateEvery(rotationInterval)
+		} else {
+			log.Print("KEY_ROTATION_INTERVAL needs a KEY_FILE to rotate into, not rotating")
+		}
 	}
 
-	clipDir := os.Getenv("CLIP_DIRECTORY")
+	dataStore, err := storage.Open(dbDsn)
+	if err != nil {
+		log.Panicf("failed to open database: %s", err)
+	}
+	defer dataStore.Close()
 
-	dbPath := os.Getenv("DATABASE_FILE")
-	if dbPath == "" {
-		dbPath = "highscores.db"
+	dataStore.SetBoardSort(boardSort)
+
+	burned, err := storage.NewBurnRegistry(dataStore)
+	if err != nil {
+		log.Panicf("failed to set up burn registry: %s", err)
 	}
 
-	frontendOrigin := os.Getenv("BACKEND_FRONTEND_ALLOWED_ORIGIN")
-	if frontendOrigin == "" {
-		frontendOrigin = "http://localhost:8000"
+	// a local bloom filter can't see ids burned by other replicas
+	if _, shared := dataStore.(*storage.PostgresStore); shared && !noBloomFilter {
+		log.Print("Database is shared between replicas, not using a bloom filter")
+	} else if !noBloomFilter {
+		burned, err = storage.NewBloomBurnRegistry(burned, 10_000_000)
+		if err != nil {
+			log.Panicf("failed to set up bloom filter: %s", err)
+		}
 	}
 
-	fmt.Printf("Configuration:\n\tManifest Path = '%s'\n\tClip Dir = '%s'\n\tDB Path = '%s'\n\tFrontend Origin = '%s'\n", manifestPath, clipDir, dbPath, frontendOrigin)
+	go storage.SweepEvery(burned, types.TOKEN_LIFETIME)
 
-	// get the manifest files
-	manifests := LoadManifests(manifestPath)
+	history, err := storage.NewHistoryStore(dataStore)
+	if err != nil {
+		log.Panicf("failed to set up run history: %s", err)
+	}
+
+	go storage.ExpireRunsEvery(history, time.Minute)
+
+	challenges, err := storage.NewChallengeStore(dataStore)
+	if err != nil {
+		log.Panicf("failed to set up challenges: %s", err)
+	}
+
+	moderation, err := storage.NewModerationStore(dataStore)
+	if err != nil {
+		log.Panicf("failed to set up moderation: %s", err)
+	}
+
+	limiter, err := ratelimit.NewLimiter(limitPolicies, sessionPolicies, trustedProxies)
+	if err != nil {
+		log.Panicf("failed to set up rate limits: %s", err)
+	}
+	go limiter.SweepEvery(time.Minute)
 
-	quizApi := api.NewQuizApi(manifests, dbPath, clipDir)
+	quizApi := api.NewQuizApi(manifests, api.CryptoChooser{}, curve, nearMiss, pow, integrity, keyRing, dataStore, burned, history, challenges, moderation, limiter, namePolicy, clipDir, links, wrapClips, adminToken, dailySecret, challengeSecret)
 
 	var debug = false
 	if os.Getenv("DEBUG") != "" {
@@ -131,12 +365,12 @@ func main() {
 
 	// init middleware
 	cors := cors.New(cors.Options{
-		AllowedHeaders: []string{"Auth-Token"},
-		ExposedHeaders: []string{"Auth-Token"},
+		AllowedHeaders: []string{"Auth-Token", "Player-Token"},
+		ExposedHeaders: []string{"Auth-Token", "Ranked", "Player-Token", "Run-Stats", "Near-Miss", "Clip-Url", "Clip-Key", "Retry-After"},
 		AllowedOrigins: []string{frontendOrigin, "http://192.168.1.29:8000"},
 		Debug:          debug,
 	})
-	handler := cors.Handler(quizApi)
+	handler := cors.Handler(limiter.Handler(quizApi))
 	handler = handlers.CombinedLoggingHandler(os.Stdout, handler)
 
 	log.Print("Listening on port 3123...")
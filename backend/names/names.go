@@ -0,0 +1,381 @@
+package names
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// the longest a name can be, in characters after normalizing
+const MAX_LENGTH = 20
+
+// Reason is why a name was rejected, for the frontend to go on.
+type Reason string
+
+const (
+	ReasonEmpty     Reason = "empty"
+	ReasonTooLong   Reason = "too-long"
+	ReasonInvisible Reason = "invisible-characters" // control, zero width and other characters you can't see
+	ReasonBadChars  Reason = "bad-characters"       // private use, unassigned, or piles of combining marks
+	ReasonMixed     Reason = "mixed-scripts"        // lookalike letters from different alphabets
+	ReasonReserved  Reason = "reserved"
+	ReasonBlocked   Reason = "blocked"
+)
+
+var messages = map[Reason]string{
+	ReasonEmpty:     "put in a name",
+	ReasonTooLong:   fmt.Sprintf("names can be at most %d characters", MAX_LENGTH),
+	ReasonInvisible: "names can't have invisible characters",
+	ReasonBadChars:  "names can't have those characters",
+	ReasonMixed:     "names can't mix alphabets",
+	ReasonReserved:  "that name is reserved",
+	ReasonBlocked:   "that name isn't allowed",
+}
+
+// Rejection is a name that didn't pass the Policy.
+type Rejection struct {
+	Reason Reason
+}
+
+func (r *Rejection) Error() string {
+	return messages[r.Reason]
+}
+
+// DefaultReserved are names nobody gets, on top of NAME_RESERVED.
+var DefaultReserved = []string{
+	"admin", "administrator", "moderator", "mod", "system", "server",
+	"clipquiz", "anonymous", "null", "undefined",
+}
+
+// DefaultBlocklist is used without a NAME_BLOCKLIST_FILE. It's short, a real
+// deployment should bring its own. Entries starting with = only match whole
+// words, the rest match anywhere in a name.
+var DefaultBlocklist = []string{
+	"fuck", "shit", "cunt", "bitch", "whore", "nigger", "faggot",
+	"=ass", "=cock", "=dick", "=fag", "=slut",
+}
+
+// Policy is what a leaderboard name has to pass.
+type Policy struct {
+	anywhere  [][]run
+	wholeWord [][]run
+	reserved  map[string]bool
+}
+
+// NewPolicy folds the lists so they match however a name is spelled, see
+// fold and runs.
+func NewPolicy(blocklist, reserved []string) *Policy {
+	p := &Policy{reserved: make(map[string]bool, len(reserved))}
+
+	for _, entry := range blocklist {
+		if strings.HasPrefix(entry, "=") {
+			if word := runs(entry[1:]); len(word) > 0 {
+				p.wholeWord = append(p.wholeWord, word)
+			}
+		} else if word := runs(entry); len(word) > 0 {
+			p.anywhere = append(p.anywhere, word)
+		}
+	}
+
+	for _, name := range reserved {
+		if folded := fold(name); folded != "" {
+			p.reserved[folded] = true
+		}
+	}
+
+	return p
+}
+
+// LoadList reads one entry a line, skipping blank lines and # comments.
+func LoadList(file string) ([]string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", file, err)
+	}
+	defer f.Close()
+
+	var entries []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			entries = append(entries, line)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", file, err)
+	}
+
+	return entries, nil
+}
+
+// Check returns the name as it should be stored, or why it can't be.
+func (p *Policy) Check(name string) (string, error) {
+	// NFKC turns fullwidth letters, ligatures and the like into plain ones
+	name = norm.NFKC.String(name)
+
+	if reason, ok := badCharacters(name); !ok {
+		return "", &Rejection{reason}
+	}
+
+	name = strings.Join(strings.Fields(name), " ")
+
+	if name == "" {
+		return "", &Rejection{ReasonEmpty}
+	}
+
+	if len([]rune(name)) > MAX_LENGTH {
+		return "", &Rejection{ReasonTooLong}
+	}
+
+	if mixedScripts(name) {
+		return "", &Rejection{ReasonMixed}
+	}
+
+	if p.reserved[fold(name)] {
+		return "", &Rejection{ReasonReserved}
+	}
+
+	if p.blocked(name) {
+		return "", &Rejection{ReasonBlocked}
+	}
+
+	return name, nil
+}
+
+// hangul fillers and friends are letters as far as unicode is concerned, but
+// they're blank
+var blank = map[rune]bool{
+	'ᅟ': true, 'ᅠ': true, 'ㅤ': true, 'ﾠ': true, '⠀': true,
+}
+
+// the most combining marks one character can carry
+const MAX_MARKS = 2
+
+func badCharacters(name string) (Reason, bool) {
+	marks := 0
+	for _, r := range name {
+		switch {
+		case r == ' ':
+		case unicode.IsControl(r), unicode.Is(unicode.Cf, r), unicode.IsSpace(r), blank[r]:
+			return ReasonInvisible, false
+		case unicode.Is(unicode.Co, r), unicode.Is(unicode.Cs, r), !unicode.IsPrint(r) && !unicode.IsMark(r):
+			return ReasonBadChars, false
+		}
+
+		if unicode.IsMark(r) {
+			marks += 1
+			if marks > MAX_MARKS {
+				return ReasonBadChars, false
+			}
+		} else {
+			marks = 0
+		}
+	}
+
+	return "", true
+}
+
+// scripts that make sense together in one name, anything else has to stick
+// to one
+var scriptGroups = [][]*unicode.RangeTable{
+	{unicode.Latin, unicode.Han, unicode.Hiragana, unicode.Katakana},
+	{unicode.Latin, unicode.Han, unicode.Hangul},
+}
+
+// mixedScripts is whether name has letters from alphabets that don't go
+// together, like a Cyrillic а in an otherwise Latin name.
+func mixedScripts(name string) bool {
+	var seen []*unicode.RangeTable
+	for _, r := range name {
+		if !unicode.IsLetter(r) {
+			continue
+		}
+
+		script := scriptOf(r)
+		if script == nil {
+			continue
+		}
+
+		found := false
+		for _, s := range seen {
+			if s == script {
+				found = true
+				break
+			}
+		}
+		if !found {
+			seen = append(seen, script)
+		}
+	}
+
+	if len(seen) <= 1 {
+		return false
+	}
+
+	for _, group := range scriptGroups {
+		if within(seen, group) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func scriptOf(r rune) *unicode.RangeTable {
+	for _, script := range unicode.Scripts {
+		if script != unicode.Common && script != unicode.Inherited && unicode.Is(script, r) {
+			return script
+		}
+	}
+	return nil
+}
+
+func within(scripts, group []*unicode.RangeTable) bool {
+	for _, script := range scripts {
+		found := false
+		for _, allowed := range group {
+			if script == allowed {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func (p *Policy) blocked(name string) bool {
+	folded := runs(name)
+	for _, word := range p.anywhere {
+		for i := range folded {
+			if stretches(folded[i:], word) {
+				return true
+			}
+		}
+	}
+
+	if len(p.wholeWord) == 0 {
+		return false
+	}
+
+	for _, token := range strings.FieldsFunc(name, separator) {
+		folded := runs(token)
+		for _, word := range p.wholeWord {
+			if len(folded) == len(word) && stretches(folded, word) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// run is a letter and how many times in a row it comes up.
+type run struct {
+	letter rune
+	count  int
+}
+
+// runs is name folded for the blocklist. It's fold, but counting letters
+// that come up twice in a row instead of dropping them, so "ass" doesn't
+// turn into "as".
+func runs(name string) []run {
+	var folded []run
+	for _, r := range normalize(name) {
+		if len(folded) > 0 && folded[len(folded)-1].letter == r {
+			folded[len(folded)-1].count++
+			continue
+		}
+		folded = append(folded, run{r, 1})
+	}
+
+	return folded
+}
+
+// stretches is whether name starts with word, letting any letter be there
+// more times in a row than in word but not fewer. "fuuuck" has "fuck" in it,
+// "as" doesn't have "ass".
+func stretches(name, word []run) bool {
+	if len(name) < len(word) {
+		return false
+	}
+
+	for i, w := range word {
+		if name[i].letter != w.letter || name[i].count < w.count {
+			return false
+		}
+	}
+
+	return true
+}
+
+// separator is what splits a name into words, anything that isn't a letter,
+// a number or standing in for a letter.
+func separator(r rune) bool {
+	_, leet := leetspeak[r]
+	return !leet && !unicode.IsLetter(r) && !unicode.IsNumber(r) && !unicode.IsMark(r)
+}
+
+var leetspeak = map[rune]rune{
+	'0': 'o', '1': 'i', '2': 'z', '3': 'e', '4': 'a', '5': 's', '6': 'g',
+	'7': 't', '8': 'b', '9': 'g', '@': 'a', '$': 's', '!': 'i', '|': 'l',
+	'+': 't', '€': 'e',
+}
+
+// lowercase Greek and Cyrillic letters that pass for Latin ones
+var lookalikes = map[rune]rune{
+	'а': 'a', 'в': 'b', 'е': 'e', 'ё': 'e', 'к': 'k', 'м': 'm', 'н': 'h',
+	'о': 'o', 'р': 'p', 'с': 'c', 'т': 't', 'у': 'y', 'х': 'x', 'ѕ': 's',
+	'і': 'i', 'ј': 'j', 'ӏ': 'l', 'ԁ': 'd', 'ԛ': 'q', 'ԝ': 'w', 'ь': 'b',
+	'г': 'r', 'α': 'a', 'β': 'b', 'ε': 'e', 'η': 'n', 'ι': 'i', 'κ': 'k',
+	'ν': 'v', 'ο': 'o', 'ρ': 'p', 'τ': 't', 'υ': 'u', 'χ': 'x', 'γ': 'y',
+	'ω': 'w',
+}
+
+// fold boils a name down so spelling tricks don't get past the reserved
+// list: normalize, and then no letter twice in a row.
+func fold(name string) string {
+	var folded []rune
+	for _, r := range normalize(name) {
+		if len(folded) > 0 && folded[len(folded)-1] == r {
+			continue
+		}
+		folded = append(folded, r)
+	}
+
+	return string(folded)
+}
+
+// normalize is lowercase, no accents, lookalikes and leetspeak turned into
+// the letters they stand for, and nothing but letters and numbers.
+func normalize(name string) []rune {
+	var normalized []rune
+	for _, r := range norm.NFKD.String(name) {
+		if unicode.IsMark(r) {
+			continue
+		}
+
+		r = unicode.ToLower(r)
+		if latin, ok := lookalikes[r]; ok {
+			r = latin
+		} else if letter, ok := leetspeak[r]; ok {
+			r = letter
+		}
+
+		if !unicode.IsLetter(r) && !unicode.IsNumber(r) {
+			continue
+		}
+
+		normalized = append(normalized, r)
+	}
+
+	return normalized
+}
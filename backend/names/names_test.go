@@ -0,0 +1,142 @@
+package names
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	policy := NewPolicy(DefaultBlocklist, append(DefaultReserved, "Darth Vader"))
+
+	tests := []struct {
+		name   string
+		want   string // what gets stored, when it's accepted
+		reason Reason // why not, when it isn't
+	}{
+		// plain names, tidied up
+		{name: "Luke", want: "Luke"},
+		{name: "  Han   Solo  ", want: "Han Solo"},
+		{name: "abcdefghijklmnopqrst", want: "abcdefghijklmnopqrst"},
+		{name: "", reason: ReasonEmpty},
+		{name: "   ", reason: ReasonEmpty},
+		{name: "abcdefghijklmnopqrstu", reason: ReasonTooLong},
+
+		// NFKC
+		{name: "Ｒｅｙ", want: "Rey"},
+		{name: "ﬁnn", want: "finn"},
+		{name: "Zoë", want: "Zoë"},
+		{name: "ａｂｃｄｅｆｇｈｉｊｋｌｍｎｏｐｑｒｓｔｕ", reason: ReasonTooLong},
+
+		// characters that shouldn't be there
+		{name: "Lu\u200bke", reason: ReasonInvisible},
+		{name: "Luke\u202e", reason: ReasonInvisible},
+		{name: "\u3164", reason: ReasonInvisible},
+		{name: "Luke\a", reason: ReasonInvisible},
+		{name: "Han\tSolo", reason: ReasonInvisible},
+		{name: "Luke\ue000", reason: ReasonBadChars},
+		{name: "x\u0301\u0302\u0303", reason: ReasonBadChars},
+		{name: "x\u0301\u0302", want: "x\u0301\u0302"},
+
+		// scripts
+		{name: "Лея", want: "Лея"},
+		{name: "Hello世界", want: "Hello世界"},
+		{name: "ルークSkywalker", want: "ルークSkywalker"},
+		{name: "한국Hello", want: "한국Hello"},
+		{name: "Pаdme", reason: ReasonMixed},    // cyrillic а
+		{name: "Ηan Solo", reason: ReasonMixed}, // greek Η
+		{name: "한국カタ", reason: ReasonMixed},
+		{name: "Лея Organa", reason: ReasonMixed},
+
+		// reserved, however it's spelled
+		{name: "admin", reason: ReasonReserved},
+		{name: "ADMIN", reason: ReasonReserved},
+		{name: "Ａｄｍｉｎ", reason: ReasonReserved},
+		{name: "4dm1n", reason: ReasonReserved},
+		{name: "@dmin", reason: ReasonReserved},
+		{name: "a d m i n", reason: ReasonReserved},
+		{name: "Addmiin", reason: ReasonReserved},
+		{name: "моԁ", reason: ReasonReserved}, // all cyrillic lookalikes
+		{name: "darth_vader", reason: ReasonReserved},
+		{name: "admin2", want: "admin2"},
+		{name: "modest", want: "modest"},
+
+		// blocked anywhere
+		{name: "shitlord", reason: ReasonBlocked},
+		{name: "sh1t happens", reason: ReasonBlocked},
+		{name: "$h!t", reason: ReasonBlocked},
+		{name: "f.u.c.k", reason: ReasonBlocked},
+		{name: "Fuuuuck", reason: ReasonBlocked},
+		{name: "fück", reason: ReasonBlocked},
+		{name: "ｆｕｃｋ", reason: ReasonBlocked},
+		{name: "ѕніт", reason: ReasonBlocked}, // all cyrillic lookalikes
+
+		// blocked as whole words only
+		{name: "big ass", reason: ReasonBlocked},
+		{name: "big @ss", reason: ReasonBlocked},
+		{name: "Dick_Grayson", reason: ReasonBlocked},
+		{name: "Class Act", want: "Class Act"},
+		{name: "Assassin", want: "Assassin"},
+		{name: "Cockpit", want: "Cockpit"},
+
+		// repeats count, a letter can be stretched but not left short
+		{name: "Fast as Light", want: "Fast as Light"},
+		{name: "Player 45", want: "Player 45"},
+		{name: "A5", want: "A5"},
+		{name: "as", want: "as"},
+		{name: "Niger Delta", want: "Niger Delta"},
+		{name: "a$$", reason: ReasonBlocked},
+		{name: "big aasss", reason: ReasonBlocked},
+		{name: "Nigggger", reason: ReasonBlocked},
+		{name: "shhhitty", reason: ReasonBlocked},
+	}
+
+	for _, test := range tests {
+		got, err := policy.Check(test.name)
+
+		if test.reason == "" {
+			if err != nil {
+				t.Errorf("Check(%q) rejected it: %s", test.name, err)
+			} else if got != test.want {
+				t.Errorf("Check(%q) = %q, want %q", test.name, got, test.want)
+			}
+			continue
+		}
+
+		var rejection *Rejection
+		if !errors.As(err, &rejection) {
+			t.Errorf("Check(%q) = %q, %v, want it rejected as %s", test.name, got, err, test.reason)
+		} else if rejection.Reason != test.reason {
+			t.Errorf("Check(%q) rejected as %s, want %s", test.name, rejection.Reason, test.reason)
+		}
+	}
+}
+
+func TestFold(t *testing.T) {
+	tests := []struct {
+		name, want string
+	}{
+		{"Admin", "admin"},
+		{"Ａｄｍｉｎ", "admin"},
+		{"Zoë", "zoe"},
+		{"5h1t", "shit"},
+		{"|337", "let"},
+		{"сοре", "cope"}, // cyrillic and greek
+		{"Aaa-bbb", "ab"},
+		{"  ", ""},
+	}
+
+	for _, test := range tests {
+		if got := fold(test.name); got != test.want {
+			t.Errorf("fold(%q) = %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestNewPolicyIgnoresEmptyEntries(t *testing.T) {
+	// all of these fold to nothing, and nothing is in every name
+	policy := NewPolicy([]string{"", "=", "...", "=--"}, []string{"", "__"})
+
+	if _, err := policy.Check("anything"); err != nil {
+		t.Errorf("an empty entry blocked a name: %s", err)
+	}
+}
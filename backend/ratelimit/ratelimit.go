@@ -0,0 +1,448 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Policy is a token bucket: Burst requests straight away, refilling at Rate
+// a second.
+type Policy struct {
+	Rate  float64
+	Burst int
+}
+
+func (p Policy) String() string {
+	return fmt.Sprintf("%g/s:%d", p.Rate, p.Burst)
+}
+
+// DefaultPolicies keeps one client from hammering the clip directory or the
+// leaderboard. Keys are a path, ones ending in / cover everything under them,
+// optionally after a method, e.g. `POST /clipquiz/v1/highscore`. A policy
+// for one method wins over one for the same path without.
+var DefaultPolicies = map[string]Policy{
+	"/clipquiz/v1/clip":             {Rate: 4, Burst: 20},
+	"GET /clipquiz/v1/highscore":    {Rate: 2, Burst: 20},
+	"POST /clipquiz/v1/highscore":   {Rate: 0.5, Burst: 10},
+	"/clipquiz/v1/challenge":        {Rate: 0.5, Burst: 10},
+	"/clipquiz/v1/leaderboard":      {Rate: 2, Burst: 20},
+	"/clipquiz/v1/leaderboard/rank": {Rate: 2, Burst: 20},
+	"/clipquiz/v1/media/":           {Rate: 10, Burst: 40},
+	"/clipquiz/v1/pow":              {Rate: 1, Burst: 10},
+	"/clipquiz/v2/run":              {Rate: 0.5, Burst: 10},
+	"/clipquiz/v2/guess":            {Rate: 4, Burst: 20},
+}
+
+// DefaultSessionPolicies are per run rather than per client, keyed the same
+// way. Lots of players behind one address still get the client limits
+// between them, but one run can't guess faster than a person could.
+var DefaultSessionPolicies = map[string]Policy{
+	"POST /clipquiz/v1/clip": {Rate: 2, Burst: 10},
+	"/clipquiz/v2/guess":     {Rate: 2, Burst: 10},
+}
+
+// ParsePolicies reads a list like `/clipquiz/v1/clip=30/1m:10`, thirty
+// requests a minute with a burst of ten, on top of defaults. A rate of `off`
+// turns a route's limit off, and `off` on its own turns them all off.
+func ParsePolicies(value string, defaults map[string]Policy) (map[string]Policy, error) {
+	policies := make(map[string]Policy, len(defaults))
+	if value == "off" {
+		return policies, nil
+	}
+
+	for route, policy := range defaults {
+		policies[route] = policy
+	}
+
+	if value == "" {
+		return policies, nil
+	}
+
+	for _, pair := range strings.Split(value, ",") {
+		parts := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("expected [METHOD ]/path=rate, got '%s'", pair)
+		}
+
+		if _, _, err := parseRoute(parts[0]); err != nil {
+			return nil, err
+		}
+
+		if parts[1] == "off" {
+			delete(policies, parts[0])
+			continue
+		}
+
+		policy, err := parsePolicy(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("bad limit for %s: %w", parts[0], err)
+		}
+		policies[parts[0]] = policy
+	}
+
+	return policies, nil
+}
+
+// parseRoute splits a policy key into its method, empty for any, and path.
+func parseRoute(route string) (string, string, error) {
+	method, path := "", route
+	if i := strings.Index(route, " "); i >= 0 {
+		method, path = route[:i], route[i+1:]
+
+		if method == "" || strings.ToUpper(method) != method {
+			return "", "", fmt.Errorf("bad method in '%s'", route)
+		}
+	}
+
+	if !strings.HasPrefix(path, "/") {
+		return "", "", fmt.Errorf("expected [METHOD ]/path, got '%s'", route)
+	}
+
+	return method, path, nil
+}
+
+// parsePolicy reads `<count>/<duration>[:<burst>]`, the burst defaults to
+// count.
+func parsePolicy(value string) (Policy, error) {
+	burst := ""
+	if i := strings.Index(value, ":"); i >= 0 {
+		value, burst = value[:i], value[i+1:]
+	}
+
+	parts := strings.SplitN(value, "/", 2)
+	if len(parts) != 2 {
+		return Policy{}, fmt.Errorf("expected count/duration, got '%s'", value)
+	}
+
+	count, err := strconv.Atoi(parts[0])
+	if err != nil || count <= 0 {
+		return Policy{}, fmt.Errorf("bad count '%s'", parts[0])
+	}
+
+	per, err := time.ParseDuration(parts[1])
+	if err != nil || per <= 0 {
+		return Policy{}, fmt.Errorf("bad duration '%s'", parts[1])
+	}
+
+	policy := Policy{Rate: float64(count) / per.Seconds(), Burst: count}
+	if burst != "" {
+		if policy.Burst, err = strconv.Atoi(burst); err != nil || policy.Burst <= 0 {
+			return Policy{}, fmt.Errorf("bad burst '%s'", burst)
+		}
+	}
+
+	return policy, nil
+}
+
+// ParseProxies reads a list of addresses or CIDRs to trust X-Forwarded-For
+// from.
+func ParseProxies(value string) ([]*net.IPNet, error) {
+	var proxies []*net.IPNet
+	if value == "" {
+		return proxies, nil
+	}
+
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("bad address '%s'", entry)
+			}
+
+			bits := 128
+			if ip.To4() != nil {
+				bits = 32
+			}
+			entry = fmt.Sprintf("%s/%d", entry, bits)
+		}
+
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("bad network '%s': %w", entry, err)
+		}
+		proxies = append(proxies, network)
+	}
+
+	return proxies, nil
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// take spends a token if there is one, otherwise it says how long until
+// there will be.
+func (b *bucket) take(policy Policy, now time.Time) (bool, time.Duration) {
+	b.tokens = math.Min(float64(policy.Burst), b.tokens+now.Sub(b.last).Seconds()*policy.Rate)
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens -= 1
+		return true, 0
+	}
+
+	return false, time.Duration((1 - b.tokens) / policy.Rate * float64(time.Second))
+}
+
+// rule is one policy and the requests it covers.
+type rule struct {
+	name    string // the key it was configured under
+	method  string // empty for any
+	path    string
+	session bool // a bucket per run instead of per client
+	policy  Policy
+}
+
+func (r *rule) covers(method, path string) bool {
+	if r.method != "" && r.method != method {
+		return false
+	}
+	return r.path == path || (strings.HasSuffix(r.path, "/") && strings.HasPrefix(path, r.path))
+}
+
+// label is how the rule shows up in Limited and Policies, session rules have
+// `session ` in front.
+func (r *rule) label() string {
+	if r.session {
+		return "session " + r.name
+	}
+	return r.name
+}
+
+type bucketKey struct {
+	rule   *rule
+	client string // an address, or a session id for session rules
+}
+
+// Limiter is middleware that gives every client its own bucket for each
+// policy, and every session its own for each session policy. Clients are told
+// apart by address, see ClientIP, and sessions by whatever Sessions says.
+type Limiter struct {
+	lock    sync.Mutex
+	buckets map[bucketKey]*bucket
+
+	rules   []*rule // most specific first
+	proxies []*net.IPNet
+	session func(req *http.Request) string
+
+	limited map[*rule]*int64
+}
+
+func NewLimiter(policies, sessionPolicies map[string]Policy, proxies []*net.IPNet) (*Limiter, error) {
+	l := &Limiter{
+		buckets: make(map[bucketKey]*bucket),
+		proxies: proxies,
+		limited: make(map[*rule]*int64, len(policies)+len(sessionPolicies)),
+	}
+
+	for i, set := range []map[string]Policy{policies, sessionPolicies} {
+		for name, policy := range set {
+			method, path, err := parseRoute(name)
+			if err != nil {
+				return nil, err
+			}
+
+			r := &rule{name: name, method: method, path: path, session: i == 1, policy: policy}
+			l.rules = append(l.rules, r)
+			l.limited[r] = new(int64)
+		}
+	}
+
+	// longest path first, then ones for a method over ones for any
+	sort.Slice(l.rules, func(i, j int) bool {
+		if len(l.rules[i].path) != len(l.rules[j].path) {
+			return len(l.rules[i].path) > len(l.rules[j].path)
+		}
+		return l.rules[i].method > l.rules[j].method
+	})
+
+	return l, nil
+}
+
+// Sessions tells the limiter how to find the session a request belongs to,
+// empty if it isn't part of one. Until it's called session policies do
+// nothing.
+func (l *Limiter) Sessions(session func(req *http.Request) string) {
+	l.session = session
+}
+
+// rulesFor is the most specific client rule and session rule covering a
+// request, either can be nil.
+func (l *Limiter) rulesFor(method, path string) (client *rule, session *rule) {
+	for _, r := range l.rules {
+		if !r.covers(method, path) {
+			continue
+		}
+
+		if r.session && session == nil {
+			session = r
+		} else if !r.session && client == nil {
+			client = r
+		}
+	}
+	return client, session
+}
+
+func (l *Limiter) trusted(ip net.IP) bool {
+	for _, network := range l.proxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP is who sent req. X-Forwarded-For only counts if it came from a
+// trusted proxy, and then it's the last address in it that isn't one of ours,
+// since anything before that could be made up.
+func (l *Limiter) clientIP(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		host = req.RemoteAddr
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil || !l.trusted(ip) {
+		return host
+	}
+
+	var forwarded []string
+	for _, header := range req.Header.Values("X-Forwarded-For") {
+		for _, entry := range strings.Split(header, ",") {
+			forwarded = append(forwarded, strings.TrimSpace(entry))
+		}
+	}
+
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		hop := net.ParseIP(forwarded[i])
+		if hop == nil {
+			// garbage, don't trust anything before it either
+			return host
+		}
+
+		host = hop.String()
+		if !l.trusted(hop) {
+			break
+		}
+	}
+
+	return host
+}
+
+type clientKey struct{}
+
+// ClientIP is the address of whoever sent req, worked out by the Limiter it
+// went through.
+func ClientIP(req *http.Request) string {
+	if ip, ok := req.Context().Value(clientKey{}).(string); ok {
+		return ip
+	}
+
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+func (l *Limiter) allow(r *rule, client string, now time.Time) (bool, time.Duration) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	key := bucketKey{r, client}
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(r.policy.Burst), last: now}
+		l.buckets[key] = b
+	}
+
+	return b.take(r.policy, now)
+}
+
+// Handler puts next behind the limits. Limited requests get a 429 with
+// Retry-After.
+func (l *Limiter) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		client := l.clientIP(req)
+		req = req.WithContext(context.WithValue(req.Context(), clientKey{}, client))
+
+		clientRule, sessionRule := l.rulesFor(req.Method, req.URL.Path)
+		now := time.Now()
+
+		if clientRule != nil {
+			if allowed, wait := l.allow(clientRule, client, now); !allowed {
+				l.reject(w, clientRule, wait)
+				return
+			}
+		}
+
+		if sessionRule != nil && l.session != nil {
+			if session := l.session(req); session != "" {
+				if allowed, wait := l.allow(sessionRule, session, now); !allowed {
+					l.reject(w, sessionRule, wait)
+					return
+				}
+			}
+		}
+
+		next.ServeHTTP(w, req)
+	})
+}
+
+func (l *Limiter) reject(w http.ResponseWriter, r *rule, wait time.Duration) {
+	atomic.AddInt64(l.limited[r], 1)
+
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	http.Error(w, "slow down", http.StatusTooManyRequests)
+}
+
+// Limited is how many requests have been turned away under each policy.
+func (l *Limiter) Limited() map[string]int64 {
+	counts := make(map[string]int64, len(l.limited))
+	for r, count := range l.limited {
+		counts[r.label()] = atomic.LoadInt64(count)
+	}
+	return counts
+}
+
+// Sweep forgets buckets that have filled back up, they're the same as new
+// ones.
+func (l *Limiter) Sweep() {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	now := time.Now()
+	for key, b := range l.buckets {
+		policy := key.rule.policy
+		if b.tokens+now.Sub(b.last).Seconds()*policy.Rate >= float64(policy.Burst) {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+func (l *Limiter) SweepEvery(interval time.Duration) {
+	for {
+		time.Sleep(interval)
+		l.Sweep()
+	}
+}
+
+// Policies is what's being limited, see Limited for the names.
+func (l *Limiter) Policies() map[string]Policy {
+	policies := make(map[string]Policy, len(l.rules))
+	for _, r := range l.rules {
+		policies[r.label()] = r.policy
+	}
+	return policies
+}
@@ -0,0 +1,246 @@
+package ratelimit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParsePolicy(t *testing.T) {
+	tests := []struct {
+		value string
+		want  Policy
+		ok    bool
+	}{
+		{"30/1m", Policy{Rate: 0.5, Burst: 30}, true},
+		{"30/1m:10", Policy{Rate: 0.5, Burst: 10}, true},
+		{"4/1s:20", Policy{Rate: 4, Burst: 20}, true},
+		{"1/500ms", Policy{Rate: 2, Burst: 1}, true},
+		{"30", Policy{}, false},
+		{"0/1m", Policy{}, false},
+		{"-1/1m", Policy{}, false},
+		{"x/1m", Policy{}, false},
+		{"30/0s", Policy{}, false},
+		{"30/soon", Policy{}, false},
+		{"30/1m:0", Policy{}, false},
+		{"30/1m:many", Policy{}, false},
+	}
+
+	for _, test := range tests {
+		got, err := parsePolicy(test.value)
+		if (err == nil) != test.ok {
+			t.Errorf("parsePolicy(%q) error = %v, want ok = %t", test.value, err, test.ok)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("parsePolicy(%q) = %v, want %v", test.value, got, test.want)
+		}
+	}
+}
+
+func TestParsePolicies(t *testing.T) {
+	defaults := map[string]Policy{
+		"/a":      {Rate: 1, Burst: 1},
+		"POST /b": {Rate: 2, Burst: 2},
+	}
+
+	tests := []struct {
+		value string
+		want  map[string]Policy
+		ok    bool
+	}{
+		{"", defaults, true},
+		{"off", map[string]Policy{}, true},
+		{"/a=off", map[string]Policy{"POST /b": {Rate: 2, Burst: 2}}, true},
+		{"GET /b=60/1m:5, /c/=1/1s", map[string]Policy{
+			"/a":      {Rate: 1, Burst: 1},
+			"POST /b": {Rate: 2, Burst: 2},
+			"GET /b":  {Rate: 1, Burst: 5},
+			"/c/":     {Rate: 1, Burst: 1},
+		}, true},
+		{"/a", nil, false},
+		{"a=1/1s", nil, false},
+		{"get /a=1/1s", nil, false},
+		{" /a=1/1s", map[string]Policy{"/a": {Rate: 1, Burst: 1}, "POST /b": {Rate: 2, Burst: 2}}, true},
+		{"/a=fast", nil, false},
+	}
+
+	for _, test := range tests {
+		got, err := ParsePolicies(test.value, defaults)
+		if (err == nil) != test.ok {
+			t.Errorf("ParsePolicies(%q) error = %v, want ok = %t", test.value, err, test.ok)
+			continue
+		}
+		if test.ok && !reflect.DeepEqual(got, test.want) {
+			t.Errorf("ParsePolicies(%q) = %v, want %v", test.value, got, test.want)
+		}
+	}
+}
+
+func TestBucketRefill(t *testing.T) {
+	policy := Policy{Rate: 2, Burst: 3}
+	start := time.Unix(1000, 0)
+	b := bucket{tokens: float64(policy.Burst), last: start}
+
+	steps := []struct {
+		after time.Duration // since start
+		ok    bool
+		wait  time.Duration
+	}{
+		// the burst
+		{0, true, 0},
+		{0, true, 0},
+		{0, true, 0},
+		{0, false, 500 * time.Millisecond},
+		// half a token isn't enough
+		{250 * time.Millisecond, false, 250 * time.Millisecond},
+		{500 * time.Millisecond, true, 0},
+		{500 * time.Millisecond, false, 500 * time.Millisecond},
+		// a long wait only fills it back up to the burst
+		{time.Minute, true, 0},
+		{time.Minute, true, 0},
+		{time.Minute, true, 0},
+		{time.Minute, false, 500 * time.Millisecond},
+	}
+
+	for i, step := range steps {
+		ok, wait := b.take(policy, start.Add(step.after))
+		if ok != step.ok || wait != step.wait {
+			t.Errorf("step %d at %s: got %t after %s, want %t after %s", i, step.after, ok, wait, step.ok, step.wait)
+		}
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	proxies, err := ParseProxies("10.0.0.0/8, 192.168.1.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := NewLimiter(nil, nil, proxies)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		remote    string
+		forwarded []string
+		want      string
+	}{
+		{"direct", "203.0.113.5:1234", nil, "203.0.113.5"},
+		{"untrusted sender's header is ignored", "203.0.113.5:1234", []string{"198.51.100.1"}, "203.0.113.5"},
+		{"one proxy", "10.1.2.3:80", []string{"198.51.100.1"}, "198.51.100.1"},
+		{"made up entries before the client don't count", "10.1.2.3:80", []string{"1.1.1.1, 198.51.100.1"}, "198.51.100.1"},
+		{"through two proxies", "10.1.2.3:80", []string{"198.51.100.1, 192.168.1.1"}, "198.51.100.1"},
+		{"split over headers", "10.1.2.3:80", []string{"1.1.1.1", "198.51.100.1, 10.9.9.9"}, "198.51.100.1"},
+		{"all proxies", "10.1.2.3:80", []string{"10.4.4.4"}, "10.4.4.4"},
+		{"garbage stops the walk", "10.1.2.3:80", []string{"198.51.100.1, nonsense"}, "10.1.2.3"},
+		{"no header", "10.1.2.3:80", nil, "10.1.2.3"},
+		{"ipv6", "[2001:db8::1]:443", nil, "2001:db8::1"},
+	}
+
+	for _, test := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = test.remote
+		for _, header := range test.forwarded {
+			req.Header.Add("X-Forwarded-For", header)
+		}
+
+		if got := l.clientIP(req); got != test.want {
+			t.Errorf("%s: clientIP = %s, want %s", test.name, got, test.want)
+		}
+	}
+}
+
+func TestRulesFor(t *testing.T) {
+	l, err := NewLimiter(map[string]Policy{
+		"/clipquiz/v1/highscore":      {Rate: 1, Burst: 1},
+		"POST /clipquiz/v1/highscore": {Rate: 1, Burst: 1},
+		"/clipquiz/v1/media/":         {Rate: 1, Burst: 1},
+	}, map[string]Policy{
+		"POST /clipquiz/v1/clip": {Rate: 1, Burst: 1},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		method, path    string
+		client, session string
+	}{
+		{"POST", "/clipquiz/v1/highscore", "POST /clipquiz/v1/highscore", ""},
+		{"GET", "/clipquiz/v1/highscore", "/clipquiz/v1/highscore", ""},
+		{"GET", "/clipquiz/v1/media/abc", "/clipquiz/v1/media/", ""},
+		{"POST", "/clipquiz/v1/clip", "", "POST /clipquiz/v1/clip"},
+		{"GET", "/clipquiz/v1/clip", "", ""},
+		{"GET", "/clipquiz/v1/highscores", "", ""},
+	}
+
+	name := func(r *rule) string {
+		if r == nil {
+			return ""
+		}
+		return r.name
+	}
+
+	for _, test := range tests {
+		client, session := l.rulesFor(test.method, test.path)
+		if name(client) != test.client || name(session) != test.session {
+			t.Errorf("%s %s: got %q and %q, want %q and %q", test.method, test.path, name(client), name(session), test.client, test.session)
+		}
+	}
+}
+
+func TestSessionLimits(t *testing.T) {
+	l, err := NewLimiter(map[string]Policy{
+		"/clip": {Rate: 0.001, Burst: 10},
+	}, map[string]Policy{
+		"/clip": {Rate: 0.001, Burst: 2},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Sessions(func(req *http.Request) string { return req.Header.Get("Session") })
+
+	handler := l.Handler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+	status := func(session string) int {
+		req := httptest.NewRequest(http.MethodPost, "/clip", nil)
+		req.Header.Set("Session", session)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// two a session, and every request counts against the client's ten
+	want := []struct {
+		session string
+		status  int
+	}{
+		{"a", http.StatusOK},
+		{"a", http.StatusOK},
+		{"a", http.StatusTooManyRequests},
+		{"b", http.StatusOK},
+		{"b", http.StatusOK},
+		{"b", http.StatusTooManyRequests},
+		{"", http.StatusOK},
+		{"", http.StatusOK},
+		{"", http.StatusOK},
+		{"c", http.StatusOK},
+		{"c", http.StatusTooManyRequests},
+	}
+
+	// the last one ran out the client's burst, not c's
+
+	for i, step := range want {
+		if got := status(step.session); got != step.status {
+			t.Errorf("request %d for session %q: got %d, want %d", i, step.session, got, step.status)
+		}
+	}
+
+	limited := l.Limited()
+	if limited["session /clip"] != 2 || limited["/clip"] != 1 {
+		t.Errorf("limited counts are %v", limited)
+	}
+}
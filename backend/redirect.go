@@ -0,0 +1,79 @@
+package main
+
+import (
+	"backend/api"
+	"backend/catalog"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// redirectLayoutCommand copies every clip in the manifests to a directory
+// under its RedirectKey, ready to upload to wherever CLIP_REDIRECT_URL points.
+// It needs the same CLIP_REDIRECT_SECRET as the server.
+func redirectLayoutCommand(args []string) int {
+	flags := flag.NewFlagSet("redirect-layout", flag.ExitOnError)
+	outDir := flags.String("out", "redirect", "directory to copy the clips to")
+	flags.Parse(args)
+
+	secret := []byte(os.Getenv("CLIP_REDIRECT_SECRET"))
+	if len(secret) == 0 {
+		fmt.Fprintln(os.Stderr, "CLIP_REDIRECT_SECRET isn't set")
+		return 2
+	}
+
+	clipDir := os.Getenv("CLIP_DIRECTORY")
+	if clipDir == "" {
+		clipDir = "."
+	}
+
+	current, err := catalog.Load(manifestPathFromEnv(), os.Getenv("CATEGORIES_FILE"), "")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to load manifests: %s\n", err)
+		return 2
+	}
+
+	if err = os.MkdirAll(*outDir, 0755); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to make %s: %s\n", *outDir, err)
+		return 2
+	}
+
+	copied := 0
+	for _, manifest := range current.Manifests {
+		for _, clip := range manifest.Keys {
+			from := filepath.Join(clipDir, clip) + ".enc"
+			to := filepath.Join(*outDir, api.RedirectKey(secret, clip))
+
+			if err = copyFile(from, to); err != nil {
+				fmt.Fprintf(os.Stderr, "failed to copy %s: %s\n", from, err)
+				return 1
+			}
+			copied++
+		}
+	}
+
+	fmt.Printf("%d clips copied to %s\n", copied, *outDir)
+	return 0
+}
+
+func copyFile(from, to string) error {
+	in, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(to)
+	if err != nil {
+		return err
+	}
+
+	if _, err = io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+
+	return out.Close()
+}
@@ -0,0 +1,146 @@
+package storage
+
+import (
+	"backend/types"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Window is how far back a board goes.
+type Window string
+
+const (
+	WindowAllTime Window = "alltime"
+	WindowWeek    Window = "week" // since the most recent sunday before today
+	WindowToday   Window = "today"
+)
+
+// Windows are the windows every mode but daily has a board for, daily boards
+// are for one day.
+var Windows = []Window{WindowAllTime, WindowWeek, WindowToday}
+
+// the most a BoardPage hands back at once
+const MAX_PAGE_SIZE = 100
+
+// ErrBadPage is a BoardPage from before the start of the board, or of
+// nothing.
+var ErrBadPage = errors.New("bad page")
+
+// Board is one leaderboard.
+type Board struct {
+	Mode       types.Mode
+	Difficulty types.Difficulty
+	Window     Window // not for daily boards
+	Day        string // only for daily boards
+}
+
+// RankedScore is a score and where it is on its board, 1 being the best.
+type RankedScore struct {
+	Rank int `json:"rank"`
+	HighScore
+}
+
+// boardWhere is the where clause and arguments that pick out board's scores.
+func boardWhere(d dialect, board Board) (string, []interface{}) {
+	where := "Mode = ? AND Difficulty = ? AND Status = 'accepted'"
+	args := []interface{}{string(board.Mode), string(board.Difficulty)}
+
+	if board.Mode == types.Daily {
+		return where + " AND Day = ?", append(args, board.Day)
+	}
+
+	if since, ok := d.windows[board.Window]; ok {
+		where += " AND " + since
+	}
+
+	return where, args
+}
+
+// sqlBoardPage does BoardPage for both sql stores, ranked by column.
+func sqlBoardPage(db *sql.DB, d dialect, column string, board Board, offset, limit int) ([]RankedScore, int, error) {
+	if offset < 0 || limit < 1 {
+		return nil, 0, ErrBadPage
+	}
+
+	where, args := boardWhere(d, board)
+
+	var total int
+	err := db.QueryRow(d.rebind(`SELECT COUNT(*) FROM highscores WHERE `+where+`;`), args...).Scan(&total)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to count board: %w", err)
+	}
+
+	rows, err := db.Query(d.rebind(`
+	SELECT
+		Name, Score, Points, Streak
+	FROM highscores
+	WHERE `+where+`
+	ORDER BY `+column+` DESC, Created ASC, Id ASC
+	LIMIT ? OFFSET ?;`), append(args, limit, offset)...)
+
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to get board page: %w", err)
+	}
+	defer rows.Close()
+
+	scores, err := parseHighscores(rows)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	ranked := make([]RankedScore, len(scores))
+	for i, score := range scores {
+		ranked[i] = RankedScore{Rank: offset + i + 1, HighScore: score}
+	}
+
+	return ranked, total, nil
+}
+
+// sqlRank does Rank for both sql stores, ranked by column. Ties go the same
+// way they do on the boards, whoever got there first.
+func sqlRank(db *sql.DB, d dialect, column string, board Board, id string) (int, error) {
+	where, args := boardWhere(d, board)
+
+	var onBoard int
+	err := db.QueryRow(d.rebind(`SELECT COUNT(*) FROM highscores WHERE `+where+` AND Id = ?;`), append(args, id)...).Scan(&onBoard)
+	if err != nil {
+		return 0, fmt.Errorf("failed to find score: %w", err)
+	}
+
+	if onBoard == 0 {
+		return 0, sql.ErrNoRows
+	}
+
+	var ahead int
+	err = db.QueryRow(d.rebind(`
+	SELECT COUNT(*) FROM highscores
+	WHERE `+where+` AND
+		(-`+column+`, Created, Id) < (SELECT -`+column+`, Created, Id FROM highscores WHERE Id = ?);`), append(args, id)...).Scan(&ahead)
+
+	if err != nil {
+		return 0, fmt.Errorf("failed to rank score: %w", err)
+	}
+
+	return ahead + 1, nil
+}
+
+// windowStart is when window starts, as of now. Weeks start on the most
+// recent sunday before today, same as the sql stores.
+func windowStart(window Window, now time.Time) time.Time {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+
+	switch window {
+	case WindowToday:
+		return today
+	case WindowWeek:
+		daysBack := int(today.Weekday())
+		if daysBack == 0 {
+			daysBack = 7
+		}
+		return today.AddDate(0, 0, -daysBack)
+	default:
+		return time.Time{}
+	}
+}
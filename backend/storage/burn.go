@@ -0,0 +1,301 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bits-and-blooms/bloom/v3"
+)
+
+type BurnKind string
+
+const (
+	BurnedId           BurnKind = "id"        // a run that has ended
+	BurnedHighscoreId  BurnKind = "highscore" // a run that has already registered a score
+	BurnedJti          BurnKind = "jti"       // a token that has already been used
+	BurnedDailyAttempt BurnKind = "daily"     // a player who has had their ranked daily attempt
+	BurnedPow          BurnKind = "pow"       // a proof of work that has already started a run
+)
+
+// BurnRegistry remembers ids that can't be used again. Entries only need to
+// be kept until every token that could carry them has expired.
+type BurnRegistry interface {
+	// Burn marks value as used until expires. It reports whether value was
+	// already burned, so checking and burning can't race.
+	Burn(kind BurnKind, value string, expires time.Time) (bool, error)
+	IsBurned(kind BurnKind, value string) (bool, error)
+
+	// Each calls fn for every entry that hasn't expired yet.
+	Each(fn func(kind BurnKind, value string)) error
+	// Sweep forgets expired entries.
+	Sweep() error
+}
+
+// SQLBurnRegistry keeps burned ids in the same database as the highscores.
+type SQLBurnRegistry struct {
+	db   *sql.DB
+	lock *sync.RWMutex
+
+	// rewrites the ? placeholders for databases that don't use them
+	rebind func(query string) string
+}
+
+// the burned table itself comes from the migrations
+func newSQLBurnRegistry(db *sql.DB, lock *sync.RWMutex, rebind func(string) string) (*SQLBurnRegistry, error) {
+	return &SQLBurnRegistry{db: db, lock: lock, rebind: rebind}, nil
+}
+
+func NewSQLiteBurnRegistry(store *Store) (*SQLBurnRegistry, error) {
+	return newSQLBurnRegistry(store.DB, &store.Lock, sqliteDialect.rebind)
+}
+
+func NewPostgresBurnRegistry(store *PostgresStore) (*SQLBurnRegistry, error) {
+	return newSQLBurnRegistry(store.DB, &store.Lock, postgresDialect.rebind)
+}
+
+func (r *SQLBurnRegistry) Burn(kind BurnKind, value string, expires time.Time) (bool, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	// an expired row is as good as no row, so let it be replaced
+	result, err := r.db.Exec(r.rebind(`
+	INSERT INTO
+		burned(Kind, Value, Expires)
+	VALUES (?, ?, ?)
+	ON CONFLICT(Kind, Value) DO UPDATE SET
+		Expires = excluded.Expires
+	WHERE burned.Expires <= ?;`), string(kind), value, expires.Unix(), time.Now().Unix())
+
+	if err != nil {
+		return false, fmt.Errorf("failed to burn %s: %w", kind, err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to burn %s: %w", kind, err)
+	}
+
+	return affected == 0, nil
+}
+
+func (r *SQLBurnRegistry) IsBurned(kind BurnKind, value string) (bool, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	var count int
+	err := r.db.QueryRow(r.rebind(`
+	SELECT
+		COUNT(*)
+	FROM burned
+	WHERE Kind = ? AND Value = ? AND Expires > ?;`), string(kind), value, time.Now().Unix()).Scan(&count)
+
+	if err != nil {
+		return false, fmt.Errorf("failed to look up %s: %w", kind, err)
+	}
+
+	return count > 0, nil
+}
+
+func (r *SQLBurnRegistry) Each(fn func(kind BurnKind, value string)) error {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	rows, err := r.db.Query(r.rebind(`
+	SELECT
+		Kind, Value
+	FROM burned
+	WHERE Expires > ?;`), time.Now().Unix())
+
+	if err != nil {
+		return fmt.Errorf("failed to list burned ids: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var kind, value string
+		if err = rows.Scan(&kind, &value); err != nil {
+			return fmt.Errorf("failed to scan row: %w", err)
+		}
+		fn(BurnKind(kind), value)
+	}
+
+	return rows.Err()
+}
+
+func (r *SQLBurnRegistry) Sweep() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	_, err := r.db.Exec(r.rebind(`DELETE FROM burned WHERE Expires <= ?;`), time.Now().Unix())
+	if err != nil {
+		return fmt.Errorf("failed to sweep burned ids: %w", err)
+	}
+
+	return nil
+}
+
+// MemoryBurnRegistry goes with MemoryStore.
+type MemoryBurnRegistry struct {
+	lock   sync.Mutex
+	burned map[string]time.Time
+}
+
+func NewMemoryBurnRegistry() *MemoryBurnRegistry {
+	return &MemoryBurnRegistry{burned: make(map[string]time.Time)}
+}
+
+func (r *MemoryBurnRegistry) Burn(kind BurnKind, value string, expires time.Time) (bool, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	key := bloomKey(kind, value)
+	if existing, ok := r.burned[key]; ok && time.Now().Before(existing) {
+		return true, nil
+	}
+
+	r.burned[key] = expires
+	return false, nil
+}
+
+func (r *MemoryBurnRegistry) IsBurned(kind BurnKind, value string) (bool, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	expires, ok := r.burned[bloomKey(kind, value)]
+	return ok && time.Now().Before(expires), nil
+}
+
+func (r *MemoryBurnRegistry) Each(fn func(kind BurnKind, value string)) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	now := time.Now()
+	for key, expires := range r.burned {
+		if now.Before(expires) {
+			parts := strings.SplitN(key, ":", 2)
+			fn(BurnKind(parts[0]), parts[1])
+		}
+	}
+
+	return nil
+}
+
+func (r *MemoryBurnRegistry) Sweep() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	now := time.Now()
+	for key, expires := range r.burned {
+		if !now.Before(expires) {
+			delete(r.burned, key)
+		}
+	}
+
+	return nil
+}
+
+// BloomBurnRegistry sits in front of another registry and answers "never
+// seen it" without touching the database. A hit in the filter might be a
+// false positive, so hits are always confirmed by the backing registry.
+type BloomBurnRegistry struct {
+	lock    sync.Mutex
+	filter  *bloom.BloomFilter
+	backing BurnRegistry
+}
+
+// NewBloomBurnRegistry wraps backing with a filter sized for about capacity
+// live entries, and fills it from whatever backing already has.
+func NewBloomBurnRegistry(backing BurnRegistry, capacity uint) (*BloomBurnRegistry, error) {
+	r := &BloomBurnRegistry{
+		filter:  bloom.NewWithEstimates(capacity, 0.00000001),
+		backing: backing,
+	}
+
+	if err := r.rebuild(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func bloomKey(kind BurnKind, value string) string {
+	return string(kind) + ":" + value
+}
+
+func (r *BloomBurnRegistry) rebuild() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.filter.ClearAll()
+
+	count := 0
+	err := r.backing.Each(func(kind BurnKind, value string) {
+		r.filter.AddString(bloomKey(kind, value))
+		count++
+	})
+
+	if err != nil {
+		return fmt.Errorf("failed to rebuild bloom filter: %w", err)
+	}
+
+	log.Printf("Bloom filter rebuilt with %d burned ids", count)
+	return nil
+}
+
+func (r *BloomBurnRegistry) Burn(kind BurnKind, value string, expires time.Time) (bool, error) {
+	r.add(kind, value)
+	burned, err := r.backing.Burn(kind, value, expires)
+
+	// add it again in case a rebuild cleared the filter before our row landed
+	r.add(kind, value)
+
+	return burned, err
+}
+
+func (r *BloomBurnRegistry) add(kind BurnKind, value string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.filter.AddString(bloomKey(kind, value))
+}
+
+func (r *BloomBurnRegistry) IsBurned(kind BurnKind, value string) (bool, error) {
+	r.lock.Lock()
+	maybe := r.filter.TestString(bloomKey(kind, value))
+	r.lock.Unlock()
+
+	if !maybe {
+		return false, nil
+	}
+
+	return r.backing.IsBurned(kind, value)
+}
+
+func (r *BloomBurnRegistry) Each(fn func(kind BurnKind, value string)) error {
+	return r.backing.Each(fn)
+}
+
+// Sweep sweeps the backing registry and then rebuilds the filter so expired
+// entries stop taking up room in it.
+func (r *BloomBurnRegistry) Sweep() error {
+	if err := r.backing.Sweep(); err != nil {
+		return err
+	}
+
+	return r.rebuild()
+}
+
+// SweepEvery sweeps registry on a schedule until the process exits.
+func SweepEvery(registry BurnRegistry, interval time.Duration) {
+	for {
+		time.Sleep(interval)
+
+		if err := registry.Sweep(); err != nil {
+			log.Printf("failed to sweep burn registry: %s", err)
+		}
+	}
+}
@@ -0,0 +1,238 @@
+package storage
+
+import (
+	"backend/types"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Challenge is a finished run that someone has dared their friends to beat.
+// Anyone starting from it hears the same clips in the same order, and their
+// run ends the way the challenger's Mode does.
+type Challenge struct {
+	Id         string
+	RunId      string
+	Difficulty types.Difficulty
+	Mode       types.Mode
+	Seed       []byte
+	Name       string
+	Score      int
+	Created    time.Time
+}
+
+// ChallengeResult is how a friend did on a challenge.
+type ChallengeResult struct {
+	ChallengeId string    `json:"-"`
+	RunId       string    `json:"-"`
+	Name        string    `json:"name"`
+	Score       int       `json:"score"`
+	Finished    time.Time `json:"finished"`
+}
+
+// ChallengeStore keeps challenges and everyone's attempts at them.
+type ChallengeStore interface {
+	// CreateChallenge saves challenge, unless its run already has one in which
+	// case that one is returned instead.
+	CreateChallenge(challenge Challenge) (Challenge, error)
+	GetChallenge(id string) (Challenge, error)
+	RecordResult(result ChallengeResult) error
+	// GetResults is every attempt at the challenge, best first.
+	GetResults(id string) ([]ChallengeResult, error)
+}
+
+// NewChallengeStore makes a challenge store that lives alongside store.
+func NewChallengeStore(store ScoreStore) (ChallengeStore, error) {
+	switch s := store.(type) {
+	case *Store:
+		return &SQLChallengeStore{db: s.DB, lock: &s.Lock, rebind: sqliteDialect.rebind}, nil
+	case *PostgresStore:
+		return &SQLChallengeStore{db: s.DB, lock: &s.Lock, rebind: postgresDialect.rebind}, nil
+	case *MemoryStore:
+		return NewMemoryChallengeStore(), nil
+	default:
+		return nil, fmt.Errorf("no challenge store for %T", store)
+	}
+}
+
+// SQLChallengeStore keeps challenges in the challenges and challenge_results
+// tables.
+type SQLChallengeStore struct {
+	db     *sql.DB
+	lock   *sync.RWMutex
+	rebind func(query string) string
+}
+
+func (c *SQLChallengeStore) CreateChallenge(challenge Challenge) (Challenge, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	_, err := c.db.Exec(c.rebind(`
+	INSERT INTO
+		challenges(Id, RunId, Difficulty, Mode, Seed, Name, Score, Created)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	ON CONFLICT(RunId) DO NOTHING;`), challenge.Id, challenge.RunId, string(challenge.Difficulty), string(challenge.Mode), hex.EncodeToString(challenge.Seed), challenge.Name, challenge.Score, challenge.Created.Unix())
+
+	if err != nil {
+		return Challenge{}, fmt.Errorf("failed to create challenge: %w", err)
+	}
+
+	return c.queryChallenge(`WHERE RunId = ?`, challenge.RunId)
+}
+
+// queryChallenge gets the one challenge matching where, the caller holds the
+// lock.
+func (c *SQLChallengeStore) queryChallenge(where string, arg string) (Challenge, error) {
+	var challenge Challenge
+	var difficulty, mode, seed string
+	var created int64
+
+	err := c.db.QueryRow(c.rebind(`
+	SELECT
+		Id, RunId, Difficulty, Mode, Seed, Name, Score, Created
+	FROM challenges
+	`+where+`;`), arg).Scan(&challenge.Id, &challenge.RunId, &difficulty, &mode, &seed, &challenge.Name, &challenge.Score, &created)
+
+	if err != nil {
+		return Challenge{}, fmt.Errorf("failed to get challenge: %w", err)
+	}
+
+	challenge.Difficulty = types.Difficulty(difficulty)
+	challenge.Mode = types.Mode(mode)
+	challenge.Created = time.Unix(created, 0)
+	if challenge.Seed, err = hex.DecodeString(seed); err != nil {
+		return Challenge{}, fmt.Errorf("failed to decode seed for challenge %s: %w", challenge.Id, err)
+	}
+
+	return challenge, nil
+}
+
+func (c *SQLChallengeStore) GetChallenge(id string) (Challenge, error) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	return c.queryChallenge(`WHERE Id = ?`, id)
+}
+
+func (c *SQLChallengeStore) RecordResult(result ChallengeResult) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	_, err := c.db.Exec(c.rebind(`
+	INSERT INTO
+		challenge_results(ChallengeId, RunId, Name, Score, Finished)
+	VALUES (?, ?, ?, ?, ?);`), result.ChallengeId, result.RunId, result.Name, result.Score, result.Finished.Unix())
+
+	if err != nil {
+		return fmt.Errorf("failed to record challenge result: %w", err)
+	}
+
+	return nil
+}
+
+func (c *SQLChallengeStore) GetResults(id string) ([]ChallengeResult, error) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	rows, err := c.db.Query(c.rebind(`
+	SELECT
+		ChallengeId, RunId, Name, Score, Finished
+	FROM challenge_results
+	WHERE ChallengeId = ?
+	ORDER BY Score DESC, Finished ASC;`), id)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to get results for challenge %s: %w", id, err)
+	}
+	defer rows.Close()
+
+	results := make([]ChallengeResult, 0)
+	for rows.Next() {
+		var result ChallengeResult
+		var finished int64
+
+		if err = rows.Scan(&result.ChallengeId, &result.RunId, &result.Name, &result.Score, &finished); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+
+		result.Finished = time.Unix(finished, 0)
+		results = append(results, result)
+	}
+
+	return results, rows.Err()
+}
+
+// MemoryChallengeStore goes with MemoryStore.
+type MemoryChallengeStore struct {
+	lock       sync.Mutex
+	challenges map[string]Challenge
+	results    []ChallengeResult
+}
+
+func NewMemoryChallengeStore() *MemoryChallengeStore {
+	return &MemoryChallengeStore{challenges: make(map[string]Challenge)}
+}
+
+func (c *MemoryChallengeStore) CreateChallenge(challenge Challenge) (Challenge, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for _, existing := range c.challenges {
+		if existing.RunId == challenge.RunId {
+			return existing, nil
+		}
+	}
+
+	c.challenges[challenge.Id] = challenge
+	return challenge, nil
+}
+
+func (c *MemoryChallengeStore) GetChallenge(id string) (Challenge, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	challenge, ok := c.challenges[id]
+	if !ok {
+		return Challenge{}, fmt.Errorf("failed to get challenge: %w", sql.ErrNoRows)
+	}
+
+	return challenge, nil
+}
+
+func (c *MemoryChallengeStore) RecordResult(result ChallengeResult) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for _, existing := range c.results {
+		if existing.RunId == result.RunId {
+			return fmt.Errorf("failed to record challenge result: duplicate run %s", result.RunId)
+		}
+	}
+
+	c.results = append(c.results, result)
+	return nil
+}
+
+func (c *MemoryChallengeStore) GetResults(id string) ([]ChallengeResult, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	results := make([]ChallengeResult, 0)
+	for _, result := range c.results {
+		if result.ChallengeId == id {
+			results = append(results, result)
+		}
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].Finished.Before(results[j].Finished)
+	})
+
+	return results, nil
+}
@@ -0,0 +1,401 @@
+package storage
+
+import (
+	"backend/types"
+	"database/sql"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+type EndReason string
+
+const (
+	EndWrongGuess    EndReason = "wrong-guess"    // guessed wrong
+	EndExpired       EndReason = "expired"        // walked away and let the token expire
+	EndPerfectClear  EndReason = "perfect-clear"  // got through every clip in the difficulty
+	EndOutOfLives    EndReason = "out-of-lives"   // lives mode, ran out of wrong answers
+	EndRoundComplete EndReason = "round-complete" // round mode, answered every question
+	EndTimeUp        EndReason = "time-up"        // blitz mode, ran out of time
+	EndDayOver       EndReason = "day-over"       // daily mode, the day ended and its recap went up
+)
+
+// RunClip is one clip served during a run, and what they guessed for it.
+type RunClip struct {
+	Seq     int           `json:"seq"`
+	Clip    string        `json:"clip"`
+	Episode types.Episode `json:"episode"`
+	Served  time.Time     `json:"served"`
+	Guess   *string       `json:"guess,omitempty"`
+	Correct *bool         `json:"correct,omitempty"`
+	Guessed *time.Time    `json:"guessed,omitempty"`
+}
+
+// Run is everything that happened in one game, keyed by TokenClaims.Id.
+type Run struct {
+	Id           string           `json:"id"`
+	Difficulty   types.Difficulty `json:"difficulty"`
+	Started      time.Time        `json:"started"`
+	LastActivity time.Time        `json:"lastActivity"`
+	Score        int              `json:"score"`
+	Ended        *time.Time       `json:"ended,omitempty"`
+	EndReason    *EndReason       `json:"endReason,omitempty"`
+	Clips        []RunClip        `json:"clips"`
+}
+
+// HistoryStore records every run as it's played, whether or not it ends
+// up on the leaderboard.
+type HistoryStore interface {
+	StartRun(id string, difficulty types.Difficulty) error
+	RecordClip(id, clip string, episode types.Episode) error
+	// RecordGuess answers the most recently served clip. score is the run's
+	// score after the guess.
+	RecordGuess(id, guess string, correct bool, score int) error
+	EndRun(id string, reason EndReason) error
+	GetRun(id string) (Run, error)
+	// ExpireRuns ends every open run with no activity since before, and
+	// returns how many it ended.
+	ExpireRuns(before time.Time) (int, error)
+	// ClipStats sums up every run by clip.
+	ClipStats() ([]ClipStats, error)
+}
+
+// NewHistoryStore makes a history store that lives alongside store.
+func NewHistoryStore(store ScoreStore) (HistoryStore, error) {
+	switch s := store.(type) {
+	case *Store:
+		return &SQLHistoryStore{db: s.DB, lock: &s.Lock, rebind: sqliteDialect.rebind}, nil
+	case *PostgresStore:
+		return &SQLHistoryStore{db: s.DB, lock: &s.Lock, rebind: postgresDialect.rebind}, nil
+	case *MemoryStore:
+		return NewMemoryHistoryStore(), nil
+	default:
+		return nil, fmt.Errorf("no history store for %T", store)
+	}
+}
+
+// SQLHistoryStore keeps runs in the runs and run_clips tables.
+type SQLHistoryStore struct {
+	db     *sql.DB
+	lock   *sync.RWMutex
+	rebind func(query string) string
+}
+
+func (h *SQLHistoryStore) StartRun(id string, difficulty types.Difficulty) error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	now := time.Now().Unix()
+	_, err := h.db.Exec(h.rebind(`
+	INSERT INTO
+		runs(Id, Difficulty, Started, LastActivity)
+	VALUES (?, ?, ?, ?);`), id, string(difficulty), now, now)
+
+	if err != nil {
+		return fmt.Errorf("failed to start run: %w", err)
+	}
+
+	return nil
+}
+
+func (h *SQLHistoryStore) RecordClip(id, clip string, episode types.Episode) error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	tx, err := h.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to record clip: %w", err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now().Unix()
+	_, err = tx.Exec(h.rebind(`
+	INSERT INTO
+		run_clips(RunId, Seq, Clip, Episode, Served)
+	VALUES (?, (SELECT COALESCE(MAX(Seq), 0) + 1 FROM run_clips WHERE RunId = ?), ?, ?, ?);`), id, id, clip, string(episode), now)
+
+	if err != nil {
+		return fmt.Errorf("failed to record clip: %w", err)
+	}
+
+	if _, err = tx.Exec(h.rebind(`UPDATE runs SET LastActivity = ? WHERE Id = ?;`), now, id); err != nil {
+		return fmt.Errorf("failed to record clip: %w", err)
+	}
+
+	return tx.Commit()
+}
+
+func (h *SQLHistoryStore) RecordGuess(id, guess string, correct bool, score int) error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	tx, err := h.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to record guess: %w", err)
+	}
+	defer tx.Rollback()
+
+	correctInt := 0
+	if correct {
+		correctInt = 1
+	}
+
+	now := time.Now().Unix()
+	_, err = tx.Exec(h.rebind(`
+	UPDATE run_clips
+	SET Guess = ?, Correct = ?, Guessed = ?
+	WHERE RunId = ? AND Seq = (SELECT MAX(Seq) FROM run_clips WHERE RunId = ?) AND Guess IS NULL;`), guess, correctInt, now, id, id)
+
+	if err != nil {
+		return fmt.Errorf("failed to record guess: %w", err)
+	}
+
+	if _, err = tx.Exec(h.rebind(`UPDATE runs SET LastActivity = ?, Score = ? WHERE Id = ?;`), now, score, id); err != nil {
+		return fmt.Errorf("failed to record guess: %w", err)
+	}
+
+	return tx.Commit()
+}
+
+func (h *SQLHistoryStore) EndRun(id string, reason EndReason) error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	now := time.Now().Unix()
+	_, err := h.db.Exec(h.rebind(`
+	UPDATE runs
+	SET Ended = ?, EndReason = ?, LastActivity = ?
+	WHERE Id = ? AND Ended IS NULL;`), now, string(reason), now, id)
+
+	if err != nil {
+		return fmt.Errorf("failed to end run: %w", err)
+	}
+
+	return nil
+}
+
+func (h *SQLHistoryStore) GetRun(id string) (Run, error) {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+
+	run := Run{Clips: make([]RunClip, 0)}
+	var started, lastActivity int64
+	var ended sql.NullInt64
+	var endReason sql.NullString
+	var difficulty string
+
+	err := h.db.QueryRow(h.rebind(`
+	SELECT
+		Id, Difficulty, Started, LastActivity, Score, Ended, EndReason
+	FROM runs
+	WHERE Id = ?;`), id).Scan(&run.Id, &difficulty, &started, &lastActivity, &run.Score, &ended, &endReason)
+
+	if err != nil {
+		return Run{}, fmt.Errorf("failed to get run %s: %w", id, err)
+	}
+
+	run.Difficulty = types.Difficulty(difficulty)
+	run.Started = time.Unix(started, 0)
+	run.LastActivity = time.Unix(lastActivity, 0)
+	if ended.Valid {
+		t := time.Unix(ended.Int64, 0)
+		run.Ended = &t
+	}
+	if endReason.Valid {
+		reason := EndReason(endReason.String)
+		run.EndReason = &reason
+	}
+
+	rows, err := h.db.Query(h.rebind(`
+	SELECT
+		Seq, Clip, Episode, Served, Guess, Correct, Guessed
+	FROM run_clips
+	WHERE RunId = ?
+	ORDER BY Seq ASC;`), id)
+
+	if err != nil {
+		return Run{}, fmt.Errorf("failed to get clips for run %s: %w", id, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var clip RunClip
+		var episode string
+		var served int64
+		var guess sql.NullString
+		var correct, guessed sql.NullInt64
+
+		if err = rows.Scan(&clip.Seq, &clip.Clip, &episode, &served, &guess, &correct, &guessed); err != nil {
+			return Run{}, fmt.Errorf("failed to scan row: %w", err)
+		}
+
+		clip.Episode = types.Episode(episode)
+		clip.Served = time.Unix(served, 0)
+		if guess.Valid {
+			clip.Guess = &guess.String
+		}
+		if correct.Valid {
+			c := correct.Int64 != 0
+			clip.Correct = &c
+		}
+		if guessed.Valid {
+			t := time.Unix(guessed.Int64, 0)
+			clip.Guessed = &t
+		}
+
+		run.Clips = append(run.Clips, clip)
+	}
+
+	return run, rows.Err()
+}
+
+func (h *SQLHistoryStore) ExpireRuns(before time.Time) (int, error) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	// they ended when their last token ran out, not when we noticed
+	result, err := h.db.Exec(h.rebind(`
+	UPDATE runs
+	SET Ended = LastActivity + ?, EndReason = ?
+	WHERE Ended IS NULL AND LastActivity < ?;`), int64(types.TOKEN_LIFETIME/time.Second), string(EndExpired), before.Unix())
+
+	if err != nil {
+		return 0, fmt.Errorf("failed to expire runs: %w", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to expire runs: %w", err)
+	}
+
+	return int(affected), nil
+}
+
+// MemoryHistoryStore goes with MemoryStore.
+type MemoryHistoryStore struct {
+	lock sync.Mutex
+	runs map[string]*Run
+}
+
+func NewMemoryHistoryStore() *MemoryHistoryStore {
+	return &MemoryHistoryStore{runs: make(map[string]*Run)}
+}
+
+func (h *MemoryHistoryStore) StartRun(id string, difficulty types.Difficulty) error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if _, ok := h.runs[id]; ok {
+		return fmt.Errorf("failed to start run: duplicate id %s", id)
+	}
+
+	now := time.Now()
+	h.runs[id] = &Run{Id: id, Difficulty: difficulty, Started: now, LastActivity: now, Clips: make([]RunClip, 0)}
+	return nil
+}
+
+func (h *MemoryHistoryStore) RecordClip(id, clip string, episode types.Episode) error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	run, ok := h.runs[id]
+	if !ok {
+		return fmt.Errorf("failed to record clip: no run %s", id)
+	}
+
+	now := time.Now()
+	run.Clips = append(run.Clips, RunClip{Seq: len(run.Clips) + 1, Clip: clip, Episode: episode, Served: now})
+	run.LastActivity = now
+	return nil
+}
+
+func (h *MemoryHistoryStore) RecordGuess(id, guess string, correct bool, score int) error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	run, ok := h.runs[id]
+	if !ok {
+		return fmt.Errorf("failed to record guess: no run %s", id)
+	}
+
+	now := time.Now()
+	if len(run.Clips) > 0 && run.Clips[len(run.Clips)-1].Guess == nil {
+		last := &run.Clips[len(run.Clips)-1]
+		last.Guess = &guess
+		last.Correct = &correct
+		last.Guessed = &now
+	}
+
+	run.LastActivity = now
+	run.Score = score
+	return nil
+}
+
+func (h *MemoryHistoryStore) EndRun(id string, reason EndReason) error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	run, ok := h.runs[id]
+	if !ok {
+		return fmt.Errorf("failed to end run: no run %s", id)
+	}
+
+	if run.Ended == nil {
+		now := time.Now()
+		run.Ended = &now
+		run.EndReason = &reason
+		run.LastActivity = now
+	}
+	return nil
+}
+
+func (h *MemoryHistoryStore) GetRun(id string) (Run, error) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	run, ok := h.runs[id]
+	if !ok {
+		return Run{}, fmt.Errorf("failed to get run %s: %w", id, sql.ErrNoRows)
+	}
+
+	copied := *run
+	copied.Clips = append([]RunClip(nil), run.Clips...)
+	return copied, nil
+}
+
+func (h *MemoryHistoryStore) ExpireRuns(before time.Time) (int, error) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	expired := 0
+	for _, run := range h.runs {
+		if run.Ended == nil && run.LastActivity.Before(before) {
+			ended := run.LastActivity.Add(types.TOKEN_LIFETIME)
+			reason := EndExpired
+			run.Ended = &ended
+			run.EndReason = &reason
+			expired++
+		}
+	}
+
+	return expired, nil
+}
+
+// ExpireRunsEvery closes out runs whose last token has expired, on a
+// schedule until the process exits.
+func ExpireRunsEvery(history HistoryStore, interval time.Duration) {
+	for {
+		time.Sleep(interval)
+
+		expired, err := history.ExpireRuns(time.Now().Add(-types.TOKEN_LIFETIME))
+		if err != nil {
+			log.Printf("failed to expire runs: %s", err)
+			continue
+		}
+
+		if expired > 0 {
+			log.Printf("Expired %d abandoned runs", expired)
+		}
+	}
+}
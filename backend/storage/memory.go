@@ -0,0 +1,305 @@
+package storage
+
+import (
+	"backend/types"
+	"database/sql"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+type memoryScore struct {
+	Id         string
+	Score      int
+	Created    time.Time
+	Name       string
+	Difficulty types.Difficulty
+	Mode       types.Mode
+	Day        string
+	Points     int
+	Streak     int
+	Status     ScoreStatus
+	Flags      []string
+}
+
+// MemoryStore keeps the leaderboard in memory, for tests and throwaway dev
+// servers. Everything is gone on restart.
+type MemoryStore struct {
+	lock   sync.RWMutex
+	scores []memoryScore
+	sort   BoardSort
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{}
+}
+
+func (s *MemoryStore) RegisterScore(entry ScoreEntry) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for _, existing := range s.scores {
+		if existing.Id == entry.Id {
+			return fmt.Errorf("failed to update database: duplicate id %s", entry.Id)
+		}
+	}
+
+	score := memoryScore{
+		Id:         entry.Id,
+		Score:      entry.Score,
+		Created:    time.Now(),
+		Name:       entry.Name,
+		Difficulty: entry.Difficulty,
+		Mode:       entry.Mode,
+		Points:     entry.Points,
+		Streak:     entry.Streak,
+		Status:     entry.status(),
+		Flags:      entry.Flags,
+	}
+	if entry.Mode == types.Daily {
+		score.Day = entry.Day
+	}
+
+	s.scores = append(s.scores, score)
+
+	return nil
+}
+
+func (m memoryScore) highScore() HighScore {
+	return HighScore{Name: m.Name, Score: m.Score, Points: m.Points, Streak: m.Streak}
+}
+
+// rankScores returns every score on mode's board for difficulty that
+// matches, ordered like the sql stores order them.
+func (s *MemoryStore) rankScores(mode types.Mode, difficulty types.Difficulty, match func(memoryScore) bool) []memoryScore {
+	matching := make([]memoryScore, 0)
+	for _, score := range s.scores {
+		if score.Mode == mode && score.Difficulty == difficulty && score.Status == StatusAccepted && match(score) {
+			matching = append(matching, score)
+		}
+	}
+
+	sort.SliceStable(matching, func(i, j int) bool {
+		a, b := s.sort.value(mode, matching[i].highScore()), s.sort.value(mode, matching[j].highScore())
+		if a != b {
+			return a > b
+		}
+		if !matching[i].Created.Equal(matching[j].Created) {
+			return matching[i].Created.Before(matching[j].Created)
+		}
+		return matching[i].Id < matching[j].Id
+	})
+
+	return matching
+}
+
+// topScores returns the best 10 scores on mode's board for difficulty that
+// match.
+func (s *MemoryStore) topScores(mode types.Mode, difficulty types.Difficulty, match func(memoryScore) bool) []HighScore {
+	matching := s.rankScores(mode, difficulty, match)
+	if len(matching) > 10 {
+		matching = matching[:10]
+	}
+
+	rows := make([]HighScore, len(matching))
+	for i, score := range matching {
+		rows[i] = score.highScore()
+	}
+
+	return rows
+}
+
+func (s *MemoryStore) QueryForHighscores() (HighScores, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	now := time.Now()
+	allScores := HighScores{}
+
+	boards := func(mode types.Mode) map[string]DifficultyHighScores {
+		byDifficulty := make(map[string]DifficultyHighScores, 4)
+		for _, difficulty := range []types.Difficulty{types.Easy, types.Medium, types.Hard, types.Legend} {
+			byDifficulty[string(difficulty)] = DifficultyHighScores{
+				AllTime: s.topScores(mode, difficulty, inWindow(WindowAllTime, now)),
+				Week:    s.topScores(mode, difficulty, inWindow(WindowWeek, now)),
+				Today:   s.topScores(mode, difficulty, inWindow(WindowToday, now)),
+			}
+		}
+		return byDifficulty
+	}
+
+	allScores.HighScores = boards(types.Classic)
+
+	allScores.Modes = make(map[string]map[string]DifficultyHighScores, len(OtherModes))
+	for _, mode := range OtherModes {
+		allScores.Modes[string(mode)] = boards(mode)
+	}
+
+	allScores.Daily = s.dailyScores(types.Today())
+
+	return allScores, nil
+}
+
+// dailyScores does DailyHighScores, the caller holds the lock.
+func (s *MemoryStore) dailyScores(day string) map[string][]HighScore {
+	daily := make(map[string][]HighScore, 4)
+	for _, difficulty := range []types.Difficulty{types.Easy, types.Medium, types.Hard, types.Legend} {
+		daily[string(difficulty)] = s.topScores(types.Daily, difficulty, func(score memoryScore) bool {
+			return score.Day == day
+		})
+	}
+
+	return daily
+}
+
+func (s *MemoryStore) DailyHighScores(day string) (map[string][]HighScore, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	return s.dailyScores(day), nil
+}
+
+func (s *MemoryStore) SetBoardSort(sort BoardSort) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.sort = sort
+}
+
+// inWindow matches the scores inside window as of now.
+func inWindow(window Window, now time.Time) func(memoryScore) bool {
+	start := windowStart(window, now)
+	return func(score memoryScore) bool {
+		return !score.Created.Before(start)
+	}
+}
+
+// boardScores is every score on board, best first.
+func (s *MemoryStore) boardScores(board Board) []memoryScore {
+	if board.Mode == types.Daily {
+		return s.rankScores(board.Mode, board.Difficulty, func(score memoryScore) bool {
+			return score.Day == board.Day
+		})
+	}
+
+	return s.rankScores(board.Mode, board.Difficulty, inWindow(board.Window, time.Now()))
+}
+
+func (s *MemoryStore) BoardPage(board Board, offset, limit int) ([]RankedScore, int, error) {
+	if offset < 0 || limit < 1 {
+		return nil, 0, ErrBadPage
+	}
+
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	scores := s.boardScores(board)
+
+	ranked := make([]RankedScore, 0)
+	for i := offset; i < len(scores) && i-offset < limit; i++ {
+		ranked = append(ranked, RankedScore{Rank: i + 1, HighScore: scores[i].highScore()})
+	}
+
+	return ranked, len(scores), nil
+}
+
+func (s *MemoryStore) Rank(board Board, id string) (int, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	for i, score := range s.boardScores(board) {
+		if score.Id == id {
+			return i + 1, nil
+		}
+	}
+
+	return 0, sql.ErrNoRows
+}
+
+func (m memoryScore) record() ScoreRecord {
+	flags := m.Flags
+	if flags == nil {
+		flags = []string{}
+	}
+
+	return ScoreRecord{
+		Id:         m.Id,
+		Name:       m.Name,
+		Difficulty: m.Difficulty,
+		Mode:       m.Mode,
+		Day:        m.Day,
+		Score:      m.Score,
+		Points:     m.Points,
+		Streak:     m.Streak,
+		Created:    m.Created,
+		Status:     m.Status,
+		Flags:      flags,
+	}
+}
+
+func (s *MemoryStore) ListScores(filter ScoreFilter) ([]ScoreRecord, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	records := make([]ScoreRecord, 0)
+	// newest first
+	for i := len(s.scores) - 1; i >= 0 && len(records) < filter.limit(); i-- {
+		if record := s.scores[i].record(); filter.matches(record) {
+			records = append(records, record)
+		}
+	}
+
+	return records, nil
+}
+
+func (s *MemoryStore) SetStatus(id string, status ScoreStatus) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for i := range s.scores {
+		if s.scores[i].Id == id {
+			s.scores[i].Status = status
+			return nil
+		}
+	}
+
+	return sql.ErrNoRows
+}
+
+func (s *MemoryStore) RenameScore(id, name string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for i := range s.scores {
+		if s.scores[i].Id == id {
+			s.scores[i].Name = name
+			return nil
+		}
+	}
+
+	return sql.ErrNoRows
+}
+
+func (s *MemoryStore) DeleteScore(id string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for i := range s.scores {
+		if s.scores[i].Id == id {
+			s.scores = append(s.scores[:i], s.scores[i+1:]...)
+			return nil
+		}
+	}
+
+	return sql.ErrNoRows
+}
+
+// GetHighScores doesn't bother caching, it's already in memory.
+func (s *MemoryStore) GetHighScores() (HighScores, error) {
+	return s.QueryForHighscores()
+}
+
+func (s *MemoryStore) Close() error {
+	return nil
+}
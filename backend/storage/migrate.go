@@ -0,0 +1,349 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed migrations
+var migrationFiles embed.FS
+
+// Migration is one file under migrations/<dialect>, named like
+// 0001_create_highscores.sql. They're applied in version order and never
+// edited once they've shipped, add a new one instead.
+type Migration struct {
+	Version int
+	Name    string
+	SQL     string
+}
+
+type dialect struct {
+	name string
+	// rewrites the ? placeholders for databases that don't use them
+	rebind func(query string) string
+	// counts tables with a given name
+	tableExists string
+	// where clauses for the scores inside each window but all time
+	windows map[Window]string
+	// lockMigrations holds off anyone else migrating the database until
+	// unlock, which is told whether the migrations went through
+	lockMigrations func(ctx context.Context, conn *sql.Conn) (unlock func(ok bool) error, err error)
+	// whether lockMigrations leaves a transaction open, so the migrations all
+	// go in that one instead of one each
+	lockIsTx bool
+}
+
+// how long to wait for another process to finish migrating
+const MIGRATION_LOCK_TIMEOUT = time.Minute
+
+// any number will do so long as nothing else takes the same advisory lock
+const MIGRATION_LOCK_ID = 0x636c6970
+
+// lockSqlite takes the whole file with an exclusive transaction, anyone else
+// migrating waits for it and then finds nothing left to do.
+func lockSqlite(ctx context.Context, conn *sql.Conn) (func(ok bool) error, error) {
+	_, err := conn.ExecContext(ctx, fmt.Sprintf(`PRAGMA busy_timeout = %d;`, MIGRATION_LOCK_TIMEOUT.Milliseconds()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to set busy timeout: %w", err)
+	}
+
+	if _, err = conn.ExecContext(ctx, `BEGIN EXCLUSIVE;`); err != nil {
+		return nil, fmt.Errorf("failed to lock db for migrations: %w", err)
+	}
+
+	return func(ok bool) error {
+		end := `ROLLBACK;`
+		if ok {
+			end = `COMMIT;`
+		}
+		_, err := conn.ExecContext(ctx, end)
+		return err
+	}, nil
+}
+
+// lockPostgres takes an advisory lock for the session, which goes away with
+// the connection if the process dies part way through.
+func lockPostgres(ctx context.Context, conn *sql.Conn) (func(ok bool) error, error) {
+	ctx, cancel := context.WithTimeout(ctx, MIGRATION_LOCK_TIMEOUT)
+	defer cancel()
+
+	if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1);`, MIGRATION_LOCK_ID); err != nil {
+		return nil, fmt.Errorf("failed to lock db for migrations: %w", err)
+	}
+
+	return func(bool) error {
+		_, err := conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1);`, MIGRATION_LOCK_ID)
+		return err
+	}, nil
+}
+
+var sqliteDialect = dialect{
+	name:           "sqlite",
+	rebind:         func(query string) string { return query },
+	tableExists:    `SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?;`,
+	lockMigrations: lockSqlite,
+	lockIsTx:       true,
+	windows: map[Window]string{
+		WindowWeek:  `Created >= DATE('now', 'weekday 0', '-7 days', 'localtime')`,
+		WindowToday: `Created >= DATE('now', 'localtime')`,
+	},
+}
+
+var postgresDialect = dialect{
+	name:           "postgres",
+	rebind:         rebindDollar,
+	tableExists:    `SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = CURRENT_SCHEMA() AND table_name = ?;`,
+	lockMigrations: lockPostgres,
+	windows: map[Window]string{
+		WindowWeek:  `Created >= CURRENT_DATE - CAST(CASE WHEN EXTRACT(DOW FROM CURRENT_DATE) = 0 THEN 7 ELSE EXTRACT(DOW FROM CURRENT_DATE) END AS INTEGER)`,
+		WindowToday: `Created >= CURRENT_DATE`,
+	},
+}
+
+func loadMigrations(d dialect) ([]Migration, error) {
+	dir := path.Join("migrations", d.name)
+	entries, err := migrationFiles.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list migrations: %w", err)
+	}
+
+	migrations := make([]Migration, 0, len(entries))
+	for _, entry := range entries {
+		name := strings.TrimSuffix(entry.Name(), ".sql")
+		parts := strings.SplitN(name, "_", 2)
+
+		version, err := strconv.Atoi(parts[0])
+		if err != nil || len(parts) != 2 {
+			return nil, fmt.Errorf("migration `%s` isn't named like 0001_name.sql", entry.Name())
+		}
+
+		bytes, err := migrationFiles.ReadFile(path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration `%s`: %w", entry.Name(), err)
+		}
+
+		migrations = append(migrations, Migration{Version: version, Name: parts[1], SQL: string(bytes)})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+
+	for i := range migrations {
+		if migrations[i].Version != i+1 {
+			return nil, fmt.Errorf("migrations for %s skip from %d to %d", d.name, i, migrations[i].Version)
+		}
+	}
+
+	return migrations, nil
+}
+
+// queryer is a db, or one connection to it.
+type queryer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+func tableExists(db queryer, d dialect, table string) (bool, error) {
+	var count int
+	if err := db.QueryRowContext(context.Background(), d.rebind(d.tableExists), table).Scan(&count); err != nil {
+		return false, fmt.Errorf("failed to check for table %s: %w", table, err)
+	}
+
+	return count > 0, nil
+}
+
+// schemaVersion returns the latest migration applied to db, 0 for an empty
+// database.
+func schemaVersion(db queryer, d dialect) (int, error) {
+	hasVersions, err := tableExists(db, d, "schema_migrations")
+	if err != nil {
+		return 0, err
+	}
+
+	if !hasVersions {
+		// databases made before migrations existed already have everything in
+		// migration 1
+		hasHighscores, err := tableExists(db, d, "highscores")
+		if err != nil {
+			return 0, err
+		}
+
+		if hasHighscores {
+			return 1, nil
+		}
+		return 0, nil
+	}
+
+	var version sql.NullInt64
+	if err = db.QueryRowContext(context.Background(), `SELECT MAX(Version) FROM schema_migrations;`).Scan(&version); err != nil {
+		return 0, fmt.Errorf("failed to get schema version: %w", err)
+	}
+
+	return int(version.Int64), nil
+}
+
+// pendingMigrations works out what migrate would apply to db, and refuses to
+// go any further if db is from a newer build than this one.
+func pendingMigrations(db queryer, d dialect) (int, []Migration, error) {
+	migrations, err := loadMigrations(d)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	version, err := schemaVersion(db, d)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if version > len(migrations) {
+		return version, nil, fmt.Errorf("database schema is version %d but this build only knows up to version %d, refusing to touch it", version, len(migrations))
+	}
+
+	return version, migrations[version:], nil
+}
+
+// migrate brings db up to the latest schema. It holds the dialect's migration
+// lock from checking the version until the last one is applied, so only one
+// process starting up at once does any of it.
+func migrate(db *sql.DB, d dialect) (err error) {
+	ctx := context.Background()
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get connection for migrations: %w", err)
+	}
+	defer conn.Close()
+
+	unlock, err := d.lockMigrations(ctx, conn)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if unlockErr := unlock(err == nil); unlockErr != nil && err == nil {
+			err = fmt.Errorf("failed to finish migrations: %w", unlockErr)
+		}
+	}()
+
+	version, pending, err := pendingMigrations(conn, d)
+	if err != nil {
+		return err
+	}
+
+	if len(pending) == 0 {
+		return nil
+	}
+
+	_, err = conn.ExecContext(ctx, `
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		Version	INTEGER NOT NULL PRIMARY KEY,
+		Name	TEXT NOT NULL,
+		Applied	TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+	);`)
+	if err != nil {
+		return fmt.Errorf("failed to create schema_migrations: %w", err)
+	}
+
+	// record that a pre-migrations database is already at version 1
+	if version == 1 {
+		var count int
+		if err = conn.QueryRowContext(ctx, `SELECT COUNT(*) FROM schema_migrations;`).Scan(&count); err != nil {
+			return fmt.Errorf("failed to check schema_migrations: %w", err)
+		}
+
+		if count == 0 {
+			_, err = conn.ExecContext(ctx, d.rebind(`INSERT INTO schema_migrations(Version, Name) VALUES (?, ?);`), 1, "existing database")
+			if err != nil {
+				return fmt.Errorf("failed to record existing schema: %w", err)
+			}
+		}
+	}
+
+	for _, m := range pending {
+		log.Printf("Applying migration %04d_%s...", m.Version, m.Name)
+
+		if err = applyMigration(ctx, conn, d, m); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// applyMigration runs m and records it, in a transaction of its own unless
+// the lock already has one open.
+func applyMigration(ctx context.Context, conn *sql.Conn, d dialect, m Migration) error {
+	var q queryer = conn
+	var tx *sql.Tx
+
+	if !d.lockIsTx {
+		var err error
+		if tx, err = conn.BeginTx(ctx, nil); err != nil {
+			return fmt.Errorf("failed to start migration %d: %w", m.Version, err)
+		}
+		q = tx
+	}
+
+	if _, err := q.ExecContext(ctx, m.SQL); err != nil {
+		if tx != nil {
+			tx.Rollback()
+		}
+		return fmt.Errorf("migration %d failed: %w", m.Version, err)
+	}
+
+	_, err := q.ExecContext(ctx, d.rebind(`INSERT INTO schema_migrations(Version, Name) VALUES (?, ?);`), m.Version, m.Name)
+	if err != nil {
+		if tx != nil {
+			tx.Rollback()
+		}
+		return fmt.Errorf("failed to record migration %d: %w", m.Version, err)
+	}
+
+	if tx != nil {
+		if err = tx.Commit(); err != nil {
+			return fmt.Errorf("failed to commit migration %d: %w", m.Version, err)
+		}
+	}
+
+	return nil
+}
+
+// PlanMigrations reports the current schema version of the database at dsn
+// and the migrations that opening it would apply, without changing anything.
+func PlanMigrations(dsn string) (int, []Migration, error) {
+	var db *sql.DB
+	var d dialect
+	var err error
+
+	switch {
+	case strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://"):
+		d = postgresDialect
+		db, err = sql.Open("postgres", dsn)
+	case strings.HasPrefix(dsn, "memory://"):
+		return 0, nil, nil
+	default:
+		file := strings.TrimPrefix(dsn, "sqlite3://")
+		if _, statErr := os.Stat(file); os.IsNotExist(statErr) {
+			// nothing there yet, it would get everything
+			migrations, err := loadMigrations(sqliteDialect)
+			return 0, migrations, err
+		}
+
+		d = sqliteDialect
+		db, err = sql.Open("sqlite3", "file:"+file+"?mode=ro")
+	}
+
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to open db: %w", err)
+	}
+	defer db.Close()
+
+	return pendingMigrations(db, d)
+}
@@ -0,0 +1,114 @@
+package storage
+
+import (
+	"database/sql"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// appliedMigrations is every row in schema_migrations, version to when it was
+// applied.
+func appliedMigrations(t *testing.T, db *sql.DB) map[int]string {
+	rows, err := db.Query(`SELECT Version, Applied FROM schema_migrations;`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	applied := make(map[int]string)
+	for rows.Next() {
+		var version int
+		var when string
+		if err = rows.Scan(&version, &when); err != nil {
+			t.Fatal(err)
+		}
+		applied[version] = when
+	}
+
+	return applied
+}
+
+func openSqlite(t *testing.T, file string) *sql.DB {
+	db, err := sql.Open("sqlite3", file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestMigrateTwice(t *testing.T) {
+	db := openSqlite(t, filepath.Join(t.TempDir(), "highscores.db"))
+
+	if err := migrate(db, sqliteDialect); err != nil {
+		t.Fatal(err)
+	}
+	first := appliedMigrations(t, db)
+
+	migrations, err := loadMigrations(sqliteDialect)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(first) != len(migrations) {
+		t.Fatalf("applied %d migrations, want %d", len(first), len(migrations))
+	}
+
+	if err = migrate(db, sqliteDialect); err != nil {
+		t.Fatal(err)
+	}
+
+	second := appliedMigrations(t, db)
+	for version, when := range first {
+		if second[version] != when {
+			t.Errorf("migration %d was applied again", version)
+		}
+	}
+	if len(second) != len(first) {
+		t.Errorf("second run left %d migrations, want %d", len(second), len(first))
+	}
+
+	version, pending, err := pendingMigrations(db, sqliteDialect)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != len(migrations) || len(pending) != 0 {
+		t.Errorf("at version %d with %d pending, want %d with none", version, len(pending), len(migrations))
+	}
+}
+
+func TestMigrateConcurrently(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "highscores.db")
+
+	// separate handles, like separate processes starting up
+	const starting = 4
+	errs := make([]error, starting)
+	var wg sync.WaitGroup
+	for i := 0; i < starting; i++ {
+		db := openSqlite(t, file)
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = migrate(db, sqliteDialect)
+		}(i)
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			t.Errorf("migration %d failed: %s", i, err)
+		}
+	}
+
+	migrations, err := loadMigrations(sqliteDialect)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if applied := appliedMigrations(t, openSqlite(t, file)); len(applied) != len(migrations) {
+		t.Errorf("applied %d migrations, want %d", len(applied), len(migrations))
+	}
+}
@@ -0,0 +1,17 @@
+CREATE TABLE highscores (
+	Id	TEXT NOT NULL PRIMARY KEY,
+	Score	INTEGER NOT NULL,
+	Created	TIMESTAMPTZ NOT NULL,
+	Name	TEXT NOT NULL,
+	Difficulty	TEXT NOT NULL
+);
+
+CREATE INDEX mainindex ON highscores (
+	Difficulty,
+	Created	DESC,
+	Score	DESC
+);
+
+CREATE INDEX otherindex ON highscores (
+	Score	DESC
+);
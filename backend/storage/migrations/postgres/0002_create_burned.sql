@@ -0,0 +1,11 @@
+-- databases from before migrations may already have this table
+CREATE TABLE IF NOT EXISTS burned (
+	Kind	TEXT NOT NULL,
+	Value	TEXT NOT NULL,
+	Expires	BIGINT NOT NULL,
+	PRIMARY KEY(Kind, Value)
+);
+
+CREATE INDEX IF NOT EXISTS burnedexpires ON burned (
+	Expires
+);
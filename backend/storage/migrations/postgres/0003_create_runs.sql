@@ -0,0 +1,30 @@
+CREATE TABLE runs (
+	Id	TEXT NOT NULL PRIMARY KEY,
+	Difficulty	TEXT NOT NULL,
+	Started	BIGINT NOT NULL,
+	LastActivity	BIGINT NOT NULL,
+	Score	INTEGER NOT NULL DEFAULT 0,
+	Ended	BIGINT,
+	EndReason	TEXT
+);
+
+CREATE INDEX runsopen ON runs (
+	Ended,
+	LastActivity
+);
+
+CREATE TABLE run_clips (
+	RunId	TEXT NOT NULL,
+	Seq	INTEGER NOT NULL,
+	Clip	TEXT NOT NULL,
+	Episode	TEXT NOT NULL,
+	Served	BIGINT NOT NULL,
+	Guess	TEXT,
+	Correct	INTEGER,
+	Guessed	BIGINT,
+	PRIMARY KEY(RunId, Seq)
+);
+
+CREATE INDEX runclipsclip ON run_clips (
+	Clip
+);
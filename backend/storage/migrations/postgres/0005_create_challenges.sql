@@ -0,0 +1,21 @@
+CREATE TABLE challenges (
+	Id	TEXT NOT NULL PRIMARY KEY,
+	RunId	TEXT NOT NULL UNIQUE,
+	Difficulty	TEXT NOT NULL,
+	Seed	TEXT NOT NULL,
+	Name	TEXT NOT NULL,
+	Score	INTEGER NOT NULL,
+	Created	BIGINT NOT NULL
+);
+
+CREATE TABLE challenge_results (
+	ChallengeId	TEXT NOT NULL,
+	RunId	TEXT NOT NULL PRIMARY KEY,
+	Name	TEXT NOT NULL,
+	Score	INTEGER NOT NULL,
+	Finished	BIGINT NOT NULL
+);
+
+CREATE INDEX challengeresultschallenge ON challenge_results (
+	ChallengeId
+);
@@ -0,0 +1,18 @@
+-- sessions and name patterns an admin has kept off the boards
+CREATE TABLE bans (
+	Kind	TEXT NOT NULL,
+	Value	TEXT NOT NULL,
+	Reason	TEXT NOT NULL,
+	Created	BIGINT NOT NULL,
+	PRIMARY KEY (Kind, Value)
+);
+
+-- everything admins have done to the boards
+CREATE TABLE audit_log (
+	Id	BIGSERIAL PRIMARY KEY,
+	Created	BIGINT NOT NULL,
+	Actor	TEXT NOT NULL,
+	Action	TEXT NOT NULL,
+	Target	TEXT NOT NULL,
+	Detail	TEXT NOT NULL
+);
@@ -0,0 +1,2 @@
+-- the mode the challenger played, a replay ends the same way theirs did
+ALTER TABLE challenges ADD COLUMN Mode TEXT NOT NULL DEFAULT 'classic';
@@ -0,0 +1,7 @@
+-- bumped on every change to the boards, so every backend sharing the
+-- database knows when its cached leaderboard is out of date
+CREATE TABLE board_version (
+	Version	BIGINT NOT NULL
+);
+
+INSERT INTO board_version (Version) VALUES (0);
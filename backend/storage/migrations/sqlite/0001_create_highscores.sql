@@ -0,0 +1,26 @@
+CREATE TABLE "highscores" (
+	"Id"	TEXT NOT NULL UNIQUE,
+	"Score"	INTEGER NOT NULL,
+	"Created"	TEXT NOT NULL,
+	"Name"	TEXT NOT NULL,
+	"Difficulty"	TEXT NOT NULL,
+	PRIMARY KEY("Id")
+);
+
+CREATE INDEX "DifficultyIndex" ON "highscores" (
+	"Difficulty"
+);
+
+CREATE INDEX "created" ON "highscores" (
+	"Created"	DESC
+);
+
+CREATE INDEX "mainindex" ON "highscores" (
+	"Difficulty",
+	"Created"	DESC,
+	"Score"	DESC
+);
+
+CREATE INDEX "otherindex" ON "highscores" (
+	"Score"	DESC
+);
@@ -0,0 +1,31 @@
+CREATE TABLE "runs" (
+	"Id"	TEXT NOT NULL,
+	"Difficulty"	TEXT NOT NULL,
+	"Started"	INTEGER NOT NULL,
+	"LastActivity"	INTEGER NOT NULL,
+	"Score"	INTEGER NOT NULL DEFAULT 0,
+	"Ended"	INTEGER,
+	"EndReason"	TEXT,
+	PRIMARY KEY("Id")
+);
+
+CREATE INDEX "runsopen" ON "runs" (
+	"Ended",
+	"LastActivity"
+);
+
+CREATE TABLE "run_clips" (
+	"RunId"	TEXT NOT NULL,
+	"Seq"	INTEGER NOT NULL,
+	"Clip"	TEXT NOT NULL,
+	"Episode"	TEXT NOT NULL,
+	"Served"	INTEGER NOT NULL,
+	"Guess"	TEXT,
+	"Correct"	INTEGER,
+	"Guessed"	INTEGER,
+	PRIMARY KEY("RunId", "Seq")
+);
+
+CREATE INDEX "runclipsclip" ON "run_clips" (
+	"Clip"
+);
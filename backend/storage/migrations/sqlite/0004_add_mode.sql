@@ -0,0 +1,11 @@
+ALTER TABLE "highscores" ADD COLUMN "Mode" TEXT NOT NULL DEFAULT 'classic';
+
+-- the day a daily challenge score is for, NULL for everything else
+ALTER TABLE "highscores" ADD COLUMN "Day" TEXT;
+
+CREATE INDEX "modeindex" ON "highscores" (
+	"Mode",
+	"Difficulty",
+	"Day",
+	"Score"	DESC
+);
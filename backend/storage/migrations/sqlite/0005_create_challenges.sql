@@ -0,0 +1,23 @@
+CREATE TABLE "challenges" (
+	"Id"	TEXT NOT NULL,
+	"RunId"	TEXT NOT NULL UNIQUE,
+	"Difficulty"	TEXT NOT NULL,
+	"Seed"	TEXT NOT NULL,
+	"Name"	TEXT NOT NULL,
+	"Score"	INTEGER NOT NULL,
+	"Created"	INTEGER NOT NULL,
+	PRIMARY KEY("Id")
+);
+
+CREATE TABLE "challenge_results" (
+	"ChallengeId"	TEXT NOT NULL,
+	"RunId"	TEXT NOT NULL,
+	"Name"	TEXT NOT NULL,
+	"Score"	INTEGER NOT NULL,
+	"Finished"	INTEGER NOT NULL,
+	PRIMARY KEY("RunId")
+);
+
+CREATE INDEX "challengeresultschallenge" ON "challenge_results" (
+	"ChallengeId"
+);
@@ -0,0 +1,18 @@
+ALTER TABLE highscores ADD COLUMN Points INTEGER NOT NULL DEFAULT 0;
+
+ALTER TABLE highscores ADD COLUMN Streak INTEGER NOT NULL DEFAULT 0;
+
+-- a sudden death score is one long streak
+UPDATE highscores SET Streak = Score WHERE Mode IN ('classic', 'daily');
+
+CREATE INDEX pointsindex ON highscores (
+	Mode,
+	Difficulty,
+	Points	DESC
+);
+
+CREATE INDEX streakindex ON highscores (
+	Mode,
+	Difficulty,
+	Streak	DESC
+);
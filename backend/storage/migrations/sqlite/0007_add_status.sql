@@ -0,0 +1,11 @@
+-- scores that look suspicious wait as pending until an admin approves or
+-- rejects them, only accepted ones are on the boards
+ALTER TABLE highscores ADD COLUMN Status TEXT NOT NULL DEFAULT 'accepted';
+
+-- why a score was held back, comma separated
+ALTER TABLE highscores ADD COLUMN Flags TEXT NOT NULL DEFAULT '';
+
+CREATE INDEX statusindex ON highscores (
+	Status,
+	Created	DESC
+);
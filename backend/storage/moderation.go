@@ -0,0 +1,312 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// BanKind is what a ban is matched against.
+type BanKind string
+
+const (
+	BanSession BanKind = "session" // a run id, the one a score is registered under
+	BanName    BanKind = "name"    // a name pattern, * for anything and ? for one character
+)
+
+// Ban keeps a run or a name off the boards.
+type Ban struct {
+	Kind    BanKind   `json:"kind"`
+	Value   string    `json:"value"`
+	Reason  string    `json:"reason"`
+	Created time.Time `json:"created"`
+}
+
+// Matches is whether value is banned. Sessions have to be exactly the same,
+// names are matched against the pattern ignoring case.
+func (b Ban) Matches(value string) bool {
+	if b.Kind == BanSession {
+		return b.Value == value
+	}
+
+	pattern := regexp.QuoteMeta(b.Value)
+	pattern = strings.ReplaceAll(pattern, `\*`, `.*`)
+	pattern = strings.ReplaceAll(pattern, `\?`, `.`)
+	matched, _ := regexp.MatchString(`(?is)^`+pattern+`$`, value)
+	return matched
+}
+
+// firstMatch is the first of bans of kind that matches value, or nil.
+func firstMatch(bans []Ban, kind BanKind, value string) *Ban {
+	for _, ban := range bans {
+		if ban.Kind == kind && ban.Matches(value) {
+			return &ban
+		}
+	}
+	return nil
+}
+
+// AuditEntry is one thing an admin did.
+type AuditEntry struct {
+	Id      int64     `json:"id"`
+	Created time.Time `json:"created"`
+	Actor   string    `json:"actor"` // their address, there's only the one admin token
+	Action  string    `json:"action"`
+	Target  string    `json:"target"` // a score id, or what was banned
+	Detail  string    `json:"detail,omitempty"`
+}
+
+// ModerationStore keeps bans and the audit log of everything admins do.
+type ModerationStore interface {
+	// Ban replaces any ban of the same kind and value.
+	Ban(ban Ban) error
+	// Unban returns sql.ErrNoRows if there's no such ban.
+	Unban(kind BanKind, value string) error
+	Bans() ([]Ban, error)
+	// Banned is the ban of kind matching value, nil if there isn't one.
+	Banned(kind BanKind, value string) (*Ban, error)
+
+	Audit(entry AuditEntry) error
+	// AuditLog is the most recent limit entries, newest first.
+	AuditLog(limit int) ([]AuditEntry, error)
+}
+
+// NewModerationStore makes a moderation store that lives alongside store.
+func NewModerationStore(store ScoreStore) (ModerationStore, error) {
+	switch s := store.(type) {
+	case *Store:
+		return &SQLModerationStore{db: s.DB, lock: &s.Lock, rebind: sqliteDialect.rebind}, nil
+	case *PostgresStore:
+		return &SQLModerationStore{db: s.DB, lock: &s.Lock, rebind: postgresDialect.rebind}, nil
+	case *MemoryStore:
+		return NewMemoryModerationStore(), nil
+	default:
+		return nil, fmt.Errorf("no moderation store for %T", store)
+	}
+}
+
+// SQLModerationStore keeps bans in the bans table and the audit log in
+// audit_log.
+type SQLModerationStore struct {
+	db     *sql.DB
+	lock   *sync.RWMutex
+	rebind func(query string) string
+}
+
+func (m *SQLModerationStore) Ban(ban Ban) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	_, err := m.db.Exec(m.rebind(`
+	INSERT INTO
+		bans(Kind, Value, Reason, Created)
+	VALUES (?, ?, ?, ?)
+	ON CONFLICT(Kind, Value) DO UPDATE SET Reason = excluded.Reason, Created = excluded.Created;`), string(ban.Kind), ban.Value, ban.Reason, ban.Created.Unix())
+
+	if err != nil {
+		return fmt.Errorf("failed to ban %s %s: %w", ban.Kind, ban.Value, err)
+	}
+
+	return nil
+}
+
+func (m *SQLModerationStore) Unban(kind BanKind, value string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	err := sqlChangeScore(m.db, m.rebind(`DELETE FROM bans WHERE Kind = ? AND Value = ?;`), string(kind), value)
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("failed to unban %s %s: %w", kind, value, err)
+	}
+	return err
+}
+
+// queryBans gets the bans matching where, the caller holds the lock.
+func (m *SQLModerationStore) queryBans(where string, args ...interface{}) ([]Ban, error) {
+	rows, err := m.db.Query(m.rebind(`
+	SELECT
+		Kind, Value, Reason, Created
+	FROM bans
+	`+where+`
+	ORDER BY Created DESC;`), args...)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to get bans: %w", err)
+	}
+	defer rows.Close()
+
+	bans := make([]Ban, 0)
+	for rows.Next() {
+		var ban Ban
+		var kind string
+		var created int64
+
+		if err = rows.Scan(&kind, &ban.Value, &ban.Reason, &created); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+
+		ban.Kind = BanKind(kind)
+		ban.Created = time.Unix(created, 0)
+		bans = append(bans, ban)
+	}
+
+	return bans, rows.Err()
+}
+
+func (m *SQLModerationStore) Bans() ([]Ban, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	return m.queryBans(``)
+}
+
+func (m *SQLModerationStore) Banned(kind BanKind, value string) (*Ban, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	var bans []Ban
+	var err error
+	if kind == BanSession {
+		bans, err = m.queryBans(`WHERE Kind = ? AND Value = ?`, string(kind), value)
+	} else {
+		// patterns have to be matched one by one
+		bans, err = m.queryBans(`WHERE Kind = ?`, string(kind))
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return firstMatch(bans, kind, value), nil
+}
+
+func (m *SQLModerationStore) Audit(entry AuditEntry) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	_, err := m.db.Exec(m.rebind(`
+	INSERT INTO
+		audit_log(Created, Actor, Action, Target, Detail)
+	VALUES (?, ?, ?, ?, ?);`), entry.Created.Unix(), entry.Actor, entry.Action, entry.Target, entry.Detail)
+
+	if err != nil {
+		return fmt.Errorf("failed to write audit log: %w", err)
+	}
+
+	return nil
+}
+
+func (m *SQLModerationStore) AuditLog(limit int) ([]AuditEntry, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	rows, err := m.db.Query(m.rebind(`
+	SELECT
+		Id, Created, Actor, Action, Target, Detail
+	FROM audit_log
+	ORDER BY Id DESC
+	LIMIT ?;`), limit)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to get audit log: %w", err)
+	}
+	defer rows.Close()
+
+	entries := make([]AuditEntry, 0)
+	for rows.Next() {
+		var entry AuditEntry
+		var created int64
+
+		if err = rows.Scan(&entry.Id, &created, &entry.Actor, &entry.Action, &entry.Target, &entry.Detail); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+
+		entry.Created = time.Unix(created, 0)
+		entries = append(entries, entry)
+	}
+
+	return entries, rows.Err()
+}
+
+// MemoryModerationStore goes with MemoryStore.
+type MemoryModerationStore struct {
+	lock  sync.Mutex
+	bans  []Ban
+	audit []AuditEntry
+}
+
+func NewMemoryModerationStore() *MemoryModerationStore {
+	return &MemoryModerationStore{}
+}
+
+func (m *MemoryModerationStore) Ban(ban Ban) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	for i, existing := range m.bans {
+		if existing.Kind == ban.Kind && existing.Value == ban.Value {
+			m.bans[i] = ban
+			return nil
+		}
+	}
+
+	m.bans = append(m.bans, ban)
+	return nil
+}
+
+func (m *MemoryModerationStore) Unban(kind BanKind, value string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	for i, existing := range m.bans {
+		if existing.Kind == kind && existing.Value == value {
+			m.bans = append(m.bans[:i], m.bans[i+1:]...)
+			return nil
+		}
+	}
+
+	return sql.ErrNoRows
+}
+
+func (m *MemoryModerationStore) Bans() ([]Ban, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	bans := make([]Ban, len(m.bans))
+	copy(bans, m.bans)
+	sort.SliceStable(bans, func(i, j int) bool { return bans[i].Created.After(bans[j].Created) })
+
+	return bans, nil
+}
+
+func (m *MemoryModerationStore) Banned(kind BanKind, value string) (*Ban, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	return firstMatch(m.bans, kind, value), nil
+}
+
+func (m *MemoryModerationStore) Audit(entry AuditEntry) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	entry.Id = int64(len(m.audit) + 1)
+	m.audit = append(m.audit, entry)
+	return nil
+}
+
+func (m *MemoryModerationStore) AuditLog(limit int) ([]AuditEntry, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	entries := make([]AuditEntry, 0)
+	for i := len(m.audit) - 1; i >= 0 && len(entries) < limit; i-- {
+		entries = append(entries, m.audit[i])
+	}
+
+	return entries, nil
+}
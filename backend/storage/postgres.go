@@ -0,0 +1,258 @@
+package storage
+
+import (
+	"backend/types"
+	"database/sql"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+
+	_ "github.com/lib/pq"
+)
+
+// PostgresStore is the ScoreStore for when several backends need to share
+// one leaderboard.
+type PostgresStore struct {
+	DB *sql.DB
+	// postgres does its own locking, this is only here so the burn registry
+	// can treat every database the same
+	Lock sync.RWMutex
+
+	cache highscoreCache
+	sort  BoardSort
+}
+
+// rebindDollar turns ? placeholders into $1, $2, ...
+func rebindDollar(query string) string {
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func NewPostgresStore(dsn string) (*PostgresStore, error) {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open db: %w", err)
+	}
+
+	if err = db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to connect to db: %w", err)
+	}
+
+	if err = migrate(db, postgresDialect); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to migrate db: %w", err)
+	}
+
+	return &PostgresStore{DB: db}, nil
+}
+
+func (s *PostgresStore) RegisterScore(entry ScoreEntry) error {
+	_, err := s.DB.Exec(`
+	INSERT INTO
+		highscores(Id, Score, Created, Name, Difficulty, Mode, Day, Points, Streak, Status, Flags)
+	VALUES ($1, $2, NOW(), $3, $4, $5, $6, $7, $8, $9, $10);`, entry.Id, entry.Score, entry.Name, string(entry.Difficulty), string(entry.Mode), nullDay(entry), entry.Points, entry.Streak, string(entry.status()), joinFlags(entry.Flags))
+
+	if err != nil {
+		return fmt.Errorf("failed to update database: %w", err)
+	}
+
+	s.changed()
+
+	return nil
+}
+
+// changed invalidates the cache here and on every other backend sharing the
+// database, see GetHighScores.
+func (s *PostgresStore) changed() {
+	s.cache.invalidate()
+
+	if _, err := s.DB.Exec(`UPDATE board_version SET Version = Version + 1;`); err != nil {
+		log.Printf("failed to bump board version, other backends will catch up within a minute: %s", err)
+	}
+}
+
+func (s *PostgresStore) QueryForHighscores() (HighScores, error) {
+	allScores := HighScores{}
+
+	classic, err := s.queryMode(types.Classic)
+	if err != nil {
+		return HighScores{}, err
+	}
+	allScores.HighScores = classic
+
+	allScores.Modes = make(map[string]map[string]DifficultyHighScores, len(OtherModes))
+	for _, mode := range OtherModes {
+		boards, err := s.queryMode(mode)
+		if err != nil {
+			return HighScores{}, fmt.Errorf("%s: %w", mode, err)
+		}
+		allScores.Modes[string(mode)] = boards
+	}
+
+	allScores.Daily, err = s.DailyHighScores(types.Today())
+	if err != nil {
+		return HighScores{}, err
+	}
+
+	return allScores, nil
+}
+
+func (s *PostgresStore) queryMode(mode types.Mode) (map[string]DifficultyHighScores, error) {
+	boards := make(map[string]DifficultyHighScores, 4)
+
+	// same windows as the sqlite store, weeks start on the most recent sunday
+	// before today
+	windows := []struct {
+		name  string
+		where string
+	}{
+		{"alltime", "TRUE"},
+		{"week", postgresDialect.windows[WindowWeek]},
+		{"day", postgresDialect.windows[WindowToday]},
+	}
+
+	for _, difficulty := range []types.Difficulty{types.Easy, types.Medium, types.Hard, types.Legend} {
+		diffScores := DifficultyHighScores{}
+
+		for _, window := range windows {
+			rows, err := s.DB.Query(`
+			SELECT
+				Name,
+				Score,
+				Points,
+				Streak
+			FROM highscores
+			WHERE Difficulty = $1 AND Mode = $2 AND Status = 'accepted' AND `+window.where+`
+			ORDER BY `+s.sort.column(mode)+` DESC, Created ASC, Id ASC
+			LIMIT 10;
+			`, string(difficulty), string(mode))
+
+			if err != nil {
+				return nil, fmt.Errorf("failed to get %s for difficulty %s: %w", window.name, string(difficulty), err)
+			}
+
+			scores, err := parseHighscores(rows)
+
+			if err != nil {
+				return nil, fmt.Errorf("parsing %s: %w", string(difficulty), err)
+			}
+
+			switch window.name {
+			case "alltime":
+				diffScores.AllTime = scores
+			case "week":
+				diffScores.Week = scores
+			case "day":
+				diffScores.Today = scores
+			}
+		}
+
+		boards[string(difficulty)] = diffScores
+	}
+
+	return boards, nil
+}
+
+func (s *PostgresStore) DailyHighScores(day string) (map[string][]HighScore, error) {
+	daily := make(map[string][]HighScore, 4)
+
+	for _, difficulty := range []types.Difficulty{types.Easy, types.Medium, types.Hard, types.Legend} {
+		rows, err := s.DB.Query(`
+		SELECT
+			Name,
+			Score,
+			Points,
+			Streak
+		FROM highscores
+		WHERE Mode = 'daily' AND Status = 'accepted' AND Difficulty = $1 AND Day = $2
+		ORDER BY `+s.sort.column(types.Daily)+` DESC, Created ASC, Id ASC
+		LIMIT 10;
+		`, string(difficulty), day)
+
+		if err != nil {
+			return nil, fmt.Errorf("failed to get daily for difficulty %s: %w", string(difficulty), err)
+		}
+
+		scores, err := parseHighscores(rows)
+
+		if err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", string(difficulty), err)
+		}
+
+		daily[string(difficulty)] = scores
+	}
+
+	return daily, nil
+}
+
+func (s *PostgresStore) SetBoardSort(sort BoardSort) {
+	s.sort = sort
+	s.cache.invalidate()
+}
+
+func (s *PostgresStore) BoardPage(board Board, offset, limit int) ([]RankedScore, int, error) {
+	return sqlBoardPage(s.DB, postgresDialect, s.sort.column(board.Mode), board, offset, limit)
+}
+
+func (s *PostgresStore) Rank(board Board, id string) (int, error) {
+	return sqlRank(s.DB, postgresDialect, s.sort.column(board.Mode), board, id)
+}
+
+func (s *PostgresStore) ListScores(filter ScoreFilter) ([]ScoreRecord, error) {
+	return sqlListScores(s.DB, postgresDialect.rebind, filter)
+}
+
+func (s *PostgresStore) SetStatus(id string, status ScoreStatus) error {
+	if err := sqlSetStatus(s.DB, postgresDialect.rebind, id, status); err != nil {
+		return err
+	}
+
+	s.changed()
+	return nil
+}
+
+func (s *PostgresStore) RenameScore(id, name string) error {
+	if err := sqlRenameScore(s.DB, postgresDialect.rebind, id, name); err != nil {
+		return err
+	}
+
+	s.changed()
+	return nil
+}
+
+func (s *PostgresStore) DeleteScore(id string) error {
+	if err := sqlDeleteScore(s.DB, postgresDialect.rebind, id); err != nil {
+		return err
+	}
+
+	s.changed()
+	return nil
+}
+
+// GetHighScores is cached, but checks the board version first so changes
+// made through any backend show up straight away.
+func (s *PostgresStore) GetHighScores() (HighScores, error) {
+	var version int64
+	if err := s.DB.QueryRow(`SELECT Version FROM board_version;`).Scan(&version); err != nil {
+		return HighScores{}, fmt.Errorf("failed to get board version: %w", err)
+	}
+
+	s.cache.sawVersion(version)
+	return s.cache.get(s.QueryForHighscores)
+}
+
+func (s *PostgresStore) Close() error {
+	return s.DB.Close()
+}
@@ -0,0 +1,217 @@
+package storage
+
+import (
+	"backend/types"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ScoreStatus is whether a score is on the boards.
+type ScoreStatus string
+
+const (
+	StatusAccepted ScoreStatus = "accepted" // on the boards
+	StatusPending  ScoreStatus = "pending"  // flagged, waiting for an admin
+	StatusRejected ScoreStatus = "rejected" // an admin said no
+	StatusHidden   ScoreStatus = "hidden"   // taken off the boards by an admin
+)
+
+// ScoreRecord is a score as it's stored, for admins.
+type ScoreRecord struct {
+	Id         string           `json:"id"`
+	Name       string           `json:"name"`
+	Difficulty types.Difficulty `json:"difficulty"`
+	Mode       types.Mode       `json:"mode"`
+	Day        string           `json:"day,omitempty"`
+	Score      int              `json:"score"`
+	Points     int              `json:"points"`
+	Streak     int              `json:"streak"`
+	Created    time.Time        `json:"created"`
+	Status     ScoreStatus      `json:"status"`
+	Flags      []string         `json:"flags"`
+}
+
+// ScoreFilter picks scores out for ListScores, anything left empty matches
+// everything. They come back newest first.
+type ScoreFilter struct {
+	Id         string
+	Status     ScoreStatus
+	Mode       types.Mode
+	Difficulty types.Difficulty
+	Name       string // anywhere in the name, ignoring case
+	Limit      int
+}
+
+const DEFAULT_SCORE_LIMIT = 100
+
+func (f ScoreFilter) limit() int {
+	if f.Limit <= 0 {
+		return DEFAULT_SCORE_LIMIT
+	}
+	return f.Limit
+}
+
+// matches does the filtering for the memory store, the sql stores do the same
+// in sqlListScores.
+func (f ScoreFilter) matches(record ScoreRecord) bool {
+	return (f.Id == "" || record.Id == f.Id) &&
+		(f.Status == "" || record.Status == f.Status) &&
+		(f.Mode == "" || record.Mode == f.Mode) &&
+		(f.Difficulty == "" || record.Difficulty == f.Difficulty) &&
+		(f.Name == "" || strings.Contains(strings.ToLower(record.Name), strings.ToLower(f.Name)))
+}
+
+// likeEscaper stops names with % or _ in them from matching more than they
+// should
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+func joinFlags(flags []string) string {
+	return strings.Join(flags, ",")
+}
+
+func splitFlags(flags string) []string {
+	if flags == "" {
+		return []string{}
+	}
+	return strings.Split(flags, ",")
+}
+
+// status is what goes in the Status column, accepted unless it says otherwise
+func (e ScoreEntry) status() ScoreStatus {
+	if e.Status == "" {
+		return StatusAccepted
+	}
+	return e.Status
+}
+
+// sqlListScores does ListScores for both sql stores.
+func sqlListScores(db *sql.DB, rebind func(string) string, filter ScoreFilter) ([]ScoreRecord, error) {
+	where := []string{"TRUE"}
+	args := []interface{}{}
+
+	if filter.Id != "" {
+		where = append(where, "Id = ?")
+		args = append(args, filter.Id)
+	}
+
+	if filter.Status != "" {
+		where = append(where, "Status = ?")
+		args = append(args, string(filter.Status))
+	}
+
+	if filter.Mode != "" {
+		where = append(where, "Mode = ?")
+		args = append(args, string(filter.Mode))
+	}
+
+	if filter.Difficulty != "" {
+		where = append(where, "Difficulty = ?")
+		args = append(args, string(filter.Difficulty))
+	}
+
+	if filter.Name != "" {
+		where = append(where, `LOWER(Name) LIKE ? ESCAPE '\'`)
+		args = append(args, "%"+likeEscaper.Replace(strings.ToLower(filter.Name))+"%")
+	}
+
+	args = append(args, filter.limit())
+
+	rows, err := db.Query(rebind(`
+	SELECT
+		Id, Name, Difficulty, Mode, Day, Score, Points, Streak, Created, Status, Flags
+	FROM highscores
+	WHERE `+strings.Join(where, " AND ")+`
+	ORDER BY Created DESC
+	LIMIT ?;`), args...)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to list scores: %w", err)
+	}
+	defer rows.Close()
+
+	records := make([]ScoreRecord, 0)
+	for rows.Next() {
+		var record ScoreRecord
+		var difficulty, mode, status, flags string
+		var day sql.NullString
+		var created interface{}
+
+		err := rows.Scan(&record.Id, &record.Name, &difficulty, &mode, &day, &record.Score, &record.Points, &record.Streak, &created, &status, &flags)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+
+		record.Difficulty = types.Difficulty(difficulty)
+		record.Mode = types.Mode(mode)
+		record.Day = day.String
+		record.Status = ScoreStatus(status)
+		record.Flags = splitFlags(flags)
+
+		// sqlite keeps it as local time text, postgres as a timestamp
+		switch created := created.(type) {
+		case time.Time:
+			record.Created = created
+		case string:
+			record.Created, _ = time.ParseInLocation("2006-01-02 15:04:05", created, time.Local)
+		case []byte:
+			record.Created, _ = time.ParseInLocation("2006-01-02 15:04:05", string(created), time.Local)
+		}
+
+		records = append(records, record)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list scores: %w", err)
+	}
+
+	return records, nil
+}
+
+// sqlChangeScore runs a statement that should change exactly the one score,
+// returning sql.ErrNoRows if it didn't.
+func sqlChangeScore(db *sql.DB, query string, args ...interface{}) error {
+	result, err := db.Exec(query, args...)
+	if err != nil {
+		return err
+	}
+
+	changed, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if changed == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// sqlSetStatus does SetStatus for both sql stores.
+func sqlSetStatus(db *sql.DB, rebind func(string) string, id string, status ScoreStatus) error {
+	err := sqlChangeScore(db, rebind(`UPDATE highscores SET Status = ? WHERE Id = ?;`), string(status), id)
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("failed to set status: %w", err)
+	}
+	return err
+}
+
+// sqlRenameScore does RenameScore for both sql stores.
+func sqlRenameScore(db *sql.DB, rebind func(string) string, id, name string) error {
+	err := sqlChangeScore(db, rebind(`UPDATE highscores SET Name = ? WHERE Id = ?;`), name, id)
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("failed to rename score: %w", err)
+	}
+	return err
+}
+
+// sqlDeleteScore does DeleteScore for both sql stores.
+func sqlDeleteScore(db *sql.DB, rebind func(string) string, id string) error {
+	err := sqlChangeScore(db, rebind(`DELETE FROM highscores WHERE Id = ?;`), id)
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("failed to delete score: %w", err)
+	}
+	return err
+}
@@ -0,0 +1,138 @@
+package storage
+
+import (
+	"backend/types"
+	"fmt"
+	"sort"
+)
+
+// ClipStats is how players have done on one clip.
+type ClipStats struct {
+	Clip    string        `json:"clip"`
+	Episode types.Episode `json:"episode"`
+	Served  int           `json:"served"`
+	Guessed int           `json:"guessed"`
+	Correct int           `json:"correct"`
+	// how many times each wrong episode was picked
+	WrongGuesses map[string]int `json:"wrongGuesses"`
+}
+
+// CorrectRate is the fraction of guesses that were right, or -1 if nobody
+// has guessed it yet.
+func (c ClipStats) CorrectRate() float64 {
+	if c.Guessed == 0 {
+		return -1
+	}
+	return float64(c.Correct) / float64(c.Guessed)
+}
+
+func sortClipStats(stats []ClipStats) {
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Clip < stats[j].Clip })
+}
+
+func (h *SQLHistoryStore) ClipStats() ([]ClipStats, error) {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+
+	rows, err := h.db.Query(`
+	SELECT
+		Clip, Episode, COUNT(*), COUNT(Guess), COALESCE(SUM(Correct), 0)
+	FROM run_clips
+	GROUP BY Clip, Episode;`)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to get clip stats: %w", err)
+	}
+	defer rows.Close()
+
+	byClip := make(map[string]*ClipStats)
+	stats := make([]ClipStats, 0)
+
+	for rows.Next() {
+		stat := ClipStats{WrongGuesses: make(map[string]int)}
+		var episode string
+
+		if err = rows.Scan(&stat.Clip, &episode, &stat.Served, &stat.Guessed, &stat.Correct); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		stat.Episode = types.Episode(episode)
+
+		stats = append(stats, stat)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get clip stats: %w", err)
+	}
+
+	for i := range stats {
+		byClip[stats[i].Clip] = &stats[i]
+	}
+
+	wrong, err := h.db.Query(`
+	SELECT
+		Clip, Guess, COUNT(*)
+	FROM run_clips
+	WHERE Correct = 0
+	GROUP BY Clip, Guess;`)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to get wrong guesses: %w", err)
+	}
+	defer wrong.Close()
+
+	for wrong.Next() {
+		var clip, guess string
+		var count int
+
+		if err = wrong.Scan(&clip, &guess, &count); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+
+		if stat, ok := byClip[clip]; ok {
+			stat.WrongGuesses[guess] += count
+		}
+	}
+
+	if err = wrong.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get wrong guesses: %w", err)
+	}
+
+	sortClipStats(stats)
+	return stats, nil
+}
+
+func (h *MemoryHistoryStore) ClipStats() ([]ClipStats, error) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	byClip := make(map[string]*ClipStats)
+	for _, run := range h.runs {
+		for _, clip := range run.Clips {
+			stat, ok := byClip[clip.Clip]
+			if !ok {
+				stat = &ClipStats{Clip: clip.Clip, Episode: clip.Episode, WrongGuesses: make(map[string]int)}
+				byClip[clip.Clip] = stat
+			}
+
+			stat.Served++
+			if clip.Guess == nil {
+				continue
+			}
+
+			stat.Guessed++
+			if *clip.Correct {
+				stat.Correct++
+			} else {
+				stat.WrongGuesses[*clip.Guess]++
+			}
+		}
+	}
+
+	stats := make([]ClipStats, 0, len(byClip))
+	for _, stat := range byClip {
+		stats = append(stats, *stat)
+	}
+
+	sortClipStats(stats)
+	return stats, nil
+}
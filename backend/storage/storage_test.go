@@ -0,0 +1,276 @@
+package storage
+
+import (
+	"backend/types"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+type seeded struct {
+	entry   ScoreEntry
+	created time.Time
+}
+
+// boardSeed has ties on score and on created time, and scores either side of
+// each window's start.
+func boardSeed(now time.Time) []seeded {
+	// sqlite only keeps seconds
+	now = now.Truncate(time.Second)
+	week := windowStart(WindowWeek, now)
+	today := windowStart(WindowToday, now)
+
+	times := []time.Time{
+		week.Add(-48 * time.Hour), // all time only
+		week.Add(time.Hour),       // this week, not today
+		today.Add(time.Second),
+		now,
+	}
+
+	var seeds []seeded
+	for i := 0; i < 24; i++ {
+		seeds = append(seeds, seeded{
+			entry: ScoreEntry{
+				Id:         fmt.Sprintf("run-%02d", (i*7)%24),
+				Name:       fmt.Sprintf("player %d", i),
+				Difficulty: types.Easy,
+				Mode:       types.Classic,
+				Score:      i % 5,
+				Points:     (i * 37) % 11,
+				Streak:     i % 3,
+			},
+			created: times[i%len(times)],
+		})
+	}
+
+	for i := 0; i < 5; i++ {
+		seeds = append(seeds, seeded{
+			entry: ScoreEntry{
+				Id:         fmt.Sprintf("daily-%d", i),
+				Name:       fmt.Sprintf("daily %d", i),
+				Difficulty: types.Easy,
+				Mode:       types.Daily,
+				Day:        "2026-01-0" + fmt.Sprint(1+i%2),
+				Score:      i % 2,
+			},
+			created: now,
+		})
+	}
+
+	// off the boards
+	seeds = append(seeds, seeded{
+		entry:   ScoreEntry{Id: "pending", Name: "pending", Difficulty: types.Easy, Mode: types.Classic, Score: 100, Status: StatusPending},
+		created: now,
+	})
+
+	return seeds
+}
+
+func seedMemory(t *testing.T, seeds []seeded) *MemoryStore {
+	store := NewMemoryStore()
+	for _, seed := range seeds {
+		if err := store.RegisterScore(seed.entry); err != nil {
+			t.Fatal(err)
+		}
+		store.scores[len(store.scores)-1].Created = seed.created
+	}
+	return store
+}
+
+func seedSQLite(t *testing.T, seeds []seeded) *Store {
+	store := &Store{}
+	store.Init(filepath.Join(t.TempDir(), "highscores.db"))
+	t.Cleanup(func() { store.Close() })
+
+	for _, seed := range seeds {
+		if err := store.RegisterScore(seed.entry); err != nil {
+			t.Fatal(err)
+		}
+
+		// sqlite keeps it as local time text
+		_, err := store.DB.Exec(`UPDATE highscores SET Created = ? WHERE Id = ?;`, seed.created.Local().Format("2006-01-02 15:04:05"), seed.entry.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return store
+}
+
+func TestStoresAgreeOnBoards(t *testing.T) {
+	seeds := boardSeed(time.Now())
+
+	boards := []Board{}
+	for _, window := range Windows {
+		boards = append(boards, Board{Mode: types.Classic, Difficulty: types.Easy, Window: window})
+	}
+	boards = append(boards, Board{Mode: types.Daily, Difficulty: types.Easy, Day: "2026-01-01"})
+
+	for _, sort := range []BoardSort{{}, {types.Classic: SortByPoints}, {types.Classic: SortByStreak}} {
+		memory := seedMemory(t, seeds)
+		sqlite := seedSQLite(t, seeds)
+		memory.SetBoardSort(sort)
+		sqlite.SetBoardSort(sort)
+
+		for _, board := range boards {
+			name := fmt.Sprintf("%v %s%s", sort, board.Window, board.Day)
+
+			want, wantTotal, err := sqlite.BoardPage(board, 0, MAX_PAGE_SIZE)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, total, err := memory.BoardPage(board, 0, MAX_PAGE_SIZE)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(got, want) || total != wantTotal {
+				t.Errorf("%s: memory board\n%v (%d)\nsqlite board\n%v (%d)", name, got, total, want, wantTotal)
+				continue
+			}
+
+			// paging is the same board in pieces
+			for offset := 0; offset < len(want); offset += 4 {
+				for _, store := range []ScoreStore{memory, sqlite} {
+					page, _, err := store.BoardPage(board, offset, 4)
+					if err != nil {
+						t.Fatal(err)
+					}
+
+					end := offset + 4
+					if end > len(want) {
+						end = len(want)
+					}
+
+					if !reflect.DeepEqual(page, want[offset:end]) {
+						t.Errorf("%s: %T page at %d is %v, want %v", name, store, offset, page, want[offset:end])
+					}
+				}
+			}
+
+			// and every score on it ranks where it's listed
+			for _, seed := range seeds {
+				memoryRank, memoryErr := memory.Rank(board, seed.entry.Id)
+				sqliteRank, sqliteErr := sqlite.Rank(board, seed.entry.Id)
+
+				if memoryRank != sqliteRank || (memoryErr == nil) != (sqliteErr == nil) {
+					t.Errorf("%s: %s ranks %d (%v) in memory but %d (%v) in sqlite", name, seed.entry.Id, memoryRank, memoryErr, sqliteRank, sqliteErr)
+					continue
+				}
+
+				if sqliteErr == nil && want[sqliteRank-1].Name != seed.entry.Name {
+					t.Errorf("%s: %s ranks %d but %s is listed there", name, seed.entry.Id, sqliteRank, want[sqliteRank-1].Name)
+				}
+			}
+		}
+
+		// the cached top 10s come out the same too
+		memoryScores, err := memory.QueryForHighscores()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		sqliteScores, err := sqlite.QueryForHighscores()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(memoryScores.HighScores, sqliteScores.HighScores) {
+			t.Errorf("%v: memory top scores\n%v\nsqlite top scores\n%v", sort, memoryScores.HighScores, sqliteScores.HighScores)
+		}
+	}
+}
+
+func TestWindows(t *testing.T) {
+	seeds := boardSeed(time.Now())
+	sqlite := seedSQLite(t, seeds)
+
+	counts := map[Window]int{}
+	for _, window := range Windows {
+		_, total, err := sqlite.BoardPage(Board{Mode: types.Classic, Difficulty: types.Easy, Window: window}, 0, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		counts[window] = total
+	}
+
+	// a quarter of the classic scores land in each stretch of time
+	want := map[Window]int{WindowAllTime: 24, WindowWeek: 18, WindowToday: 12}
+	if !reflect.DeepEqual(counts, want) {
+		t.Errorf("board sizes are %v, want %v", counts, want)
+	}
+}
+
+func TestBoardPageBounds(t *testing.T) {
+	seeds := boardSeed(time.Now())
+	stores := map[string]ScoreStore{
+		"memory": seedMemory(t, seeds),
+		"sqlite": seedSQLite(t, seeds),
+	}
+	board := Board{Mode: types.Classic, Difficulty: types.Easy, Window: WindowAllTime}
+	maxInt := int(^uint(0) >> 1)
+
+	tests := []struct {
+		offset, limit int
+		bad           bool
+	}{
+		{-1, 10, true},
+		{-maxInt - 1, 2, true},
+		{0, 0, true},
+		{0, -5, true},
+		{maxInt - 1, 2, false},
+		{1000, MAX_PAGE_SIZE, false},
+	}
+
+	for name, store := range stores {
+		for _, test := range tests {
+			scores, _, err := store.BoardPage(board, test.offset, test.limit)
+			if test.bad {
+				if !errors.Is(err, ErrBadPage) {
+					t.Errorf("%s: BoardPage(%d, %d) = %v, want ErrBadPage", name, test.offset, test.limit, err)
+				}
+				continue
+			}
+
+			if err != nil || len(scores) != 0 {
+				t.Errorf("%s: BoardPage(%d, %d) = %d scores, %v, want none past the end", name, test.offset, test.limit, len(scores), err)
+			}
+		}
+	}
+}
+
+func TestHighscoreCacheVersion(t *testing.T) {
+	var cache highscoreCache
+	queries := 0
+	query := func() (HighScores, error) {
+		queries++
+		return HighScores{}, nil
+	}
+
+	steps := []struct {
+		version int64
+		queries int
+	}{
+		{0, 1},
+		{0, 1},
+		// another backend changed the boards
+		{1, 2},
+		{1, 2},
+		{2, 3},
+	}
+
+	for i, step := range steps {
+		cache.sawVersion(step.version)
+		if _, err := cache.get(query); err != nil {
+			t.Fatal(err)
+		}
+		if queries != step.queries {
+			t.Errorf("step %d at version %d: %d queries, want %d", i, step.version, queries, step.queries)
+		}
+	}
+}
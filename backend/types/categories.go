@@ -0,0 +1,108 @@
+package types
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+)
+
+// Category is something a clip can be from: a film, a series, etc.
+type Category struct {
+	Id    Episode `json:"id"`
+	Name  string  `json:"name"`
+	Order int     `json:"order"`
+	// optional, categories in the same group are shown together, and guessing
+	// the wrong one from the right group is a near miss (trilogies)
+	Group string `json:"group,omitempty"`
+}
+
+// Registry is every category the quiz knows about, in display order.
+type Registry struct {
+	Categories []Category `json:"categories"`
+
+	byId map[Episode]Category
+}
+
+func NewRegistry(categories []Category) (*Registry, error) {
+	r := &Registry{
+		Categories: append([]Category(nil), categories...),
+		byId:       make(map[Episode]Category, len(categories)),
+	}
+
+	for _, category := range r.Categories {
+		if category.Id == "" {
+			return nil, fmt.Errorf("category %q has no id", category.Name)
+		}
+
+		if _, ok := r.byId[category.Id]; ok {
+			return nil, fmt.Errorf("category %s is registered twice", category.Id)
+		}
+
+		r.byId[category.Id] = category
+	}
+
+	sort.SliceStable(r.Categories, func(i, j int) bool { return r.Categories[i].Order < r.Categories[j].Order })
+
+	return r, nil
+}
+
+// DefaultRegistry is the six films, used when no categories file is given.
+func DefaultRegistry() *Registry {
+	r, err := NewRegistry([]Category{
+		{Id: PhantomMenace, Name: "Episode I: The Phantom Menace", Order: 1, Group: "prequels"},
+		{Id: AttackClones, Name: "Episode II: Attack of the Clones", Order: 2, Group: "prequels"},
+		{Id: RevengeSith, Name: "Episode III: Revenge of the Sith", Order: 3, Group: "prequels"},
+		{Id: NewHope, Name: "Episode IV: A New Hope", Order: 4, Group: "originals"},
+		{Id: Empire, Name: "Episode V: The Empire Strikes Back", Order: 5, Group: "originals"},
+		{Id: Rotj, Name: "Episode VI: Return of the Jedi", Order: 6, Group: "originals"},
+	})
+
+	if err != nil {
+		panic(err)
+	}
+
+	return r
+}
+
+// LoadRegistry reads a categories file, which looks like
+//
+//	{"categories": [{"id": "new-hope", "name": "A New Hope", "order": 4, "group": "originals"}, ...]}
+func LoadRegistry(file string) (*Registry, error) {
+	bytes, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read '%s': %w", file, err)
+	}
+
+	var parsed Registry
+	if err = json.Unmarshal(bytes, &parsed); err != nil {
+		return nil, fmt.Errorf("failed to parse '%s': %w", file, err)
+	}
+
+	if len(parsed.Categories) == 0 {
+		return nil, fmt.Errorf("'%s' has no categories", file)
+	}
+
+	return NewRegistry(parsed.Categories)
+}
+
+func (r *Registry) Get(id Episode) (Category, bool) {
+	category, ok := r.byId[id]
+	return category, ok
+}
+
+// SameGroup is whether a and b are different categories from one group.
+// Categories without a group are never in one.
+func (r *Registry) SameGroup(a, b Episode) bool {
+	group := r.byId[a].Group
+	return group != "" && a != b && group == r.byId[b].Group
+}
+
+// Ids returns every category id in display order.
+func (r *Registry) Ids() []Episode {
+	ids := make([]Episode, len(r.Categories))
+	for i, category := range r.Categories {
+		ids[i] = category.Id
+	}
+	return ids
+}
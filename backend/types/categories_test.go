@@ -0,0 +1,39 @@
+package types
+
+import "testing"
+
+func TestSameGroup(t *testing.T) {
+	var rogue, mandalorian, andor Episode = "rogue-one", "mandalorian", "andor"
+
+	custom, err := NewRegistry([]Category{
+		{Id: NewHope, Name: "A New Hope", Group: "skywalker"},
+		{Id: rogue, Name: "Rogue One", Group: "skywalker"},
+		{Id: mandalorian, Name: "The Mandalorian"},
+		{Id: andor, Name: "Andor"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		registry *Registry
+		a, b     Episode
+		want     bool
+	}{
+		{DefaultRegistry(), PhantomMenace, RevengeSith, true},
+		{DefaultRegistry(), NewHope, Rotj, true},
+		{DefaultRegistry(), NewHope, AttackClones, false},
+		{DefaultRegistry(), Empire, Empire, false},
+		{DefaultRegistry(), Empire, "not-a-film", false},
+		{custom, NewHope, rogue, true},
+		{custom, NewHope, mandalorian, false},
+		// no group isn't a group of its own
+		{custom, mandalorian, andor, false},
+	}
+
+	for _, test := range tests {
+		if got := test.registry.SameGroup(test.a, test.b); got != test.want {
+			t.Errorf("SameGroup(%s, %s) = %t, want %t", test.a, test.b, got, test.want)
+		}
+	}
+}
@@ -1,12 +1,18 @@
 package types
 
+import "time"
+
 type RandomManifest struct {
 	Lookup map[string]Episode
 	Keys   []string
 }
 
-func (m *Manifest) TotalSize() int {
-	return len(m.PhantomMenace) + len(m.AttackClones) + len(m.RevengeSith) + len(m.NewHope) + len(m.Empire) + len(m.Rotj)
+func (m Manifest) TotalSize() int {
+	total := 0
+	for _, clips := range m {
+		total += len(clips)
+	}
+	return total
 }
 
 var ClipDir string
@@ -21,8 +27,31 @@ const (
 	Legend Difficulty = "legend"
 )
 
+// Mode is which game they're playing. Ranked modes each have their own
+// leaderboard.
+type Mode string
+
+const (
+	Classic   Mode = "classic"   // sudden death, random clips, first wrong answer ends it
+	Daily     Mode = "daily"     // everyone gets the same clips in the same order for the day
+	Challenge Mode = "challenge" // replaying someone else's run, never ranked
+	Lives     Mode = "lives"     // random clips, LIVES wrong answers ends it
+	Round     Mode = "round"     // random clips, ROUND_LENGTH of them whether right or wrong
+	Blitz     Mode = "blitz"     // random clips, as many as they can get right in BLITZ_BUDGET
+)
+
+// Today is the current calendar day in UTC, which is what daily challenges go
+// by, so every server agrees on it. The "today" leaderboards go by server
+// time.
+func Today() string {
+	return time.Now().UTC().Format("2006-01-02")
+}
+
+// Episode is a category id, see Registry. It's called Episode because it
+// used to only ever be one of the six films.
 type Episode string
 
+// the original six, these make up the default registry
 var (
 	PhantomMenace Episode = "phantom-menace"
 	AttackClones  Episode = "attack-clones"
@@ -32,13 +61,23 @@ var (
 	Rotj          Episode = "rotj"
 )
 
-type Manifest struct {
-	PhantomMenace []string `json:"phantom-menace"`
-	AttackClones  []string `json:"attack-clones"`
-	RevengeSith   []string `json:"revenge-sith"`
-	NewHope       []string `json:"new-hope"`
-	Empire        []string `json:"empire"`
-	Rotj          []string `json:"rotj"`
-}
+// Manifest lists the clips for each category in one difficulty. The keys are
+// category ids, so the old six-episode manifests load as-is.
+type Manifest map[Episode][]string
 
 const SIGNATURE_LENGTH = 32
+
+// how long a token is good for after it was issued
+const TOKEN_LIFETIME = time.Minute * 15
+
+// how many wrong answers a lives run gets
+const LIVES = 3
+
+// how many clips are in a round, the same as the frontend's NUM_QUESTIONS
+const ROUND_LENGTH = 10
+
+// how long a blitz run lasts from its first clip
+const BLITZ_BUDGET = time.Second * 60
+
+// how long a signed clip url is good for, by default
+const CLIP_URL_LIFETIME = time.Minute * 5
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"backend/catalog"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+)
+
+// validateCommand checks the manifests against each other and the clip
+// directory, and exits non-zero if anything is wrong so it can gate a
+// content pipeline.
+func validateCommand(args []string) int {
+	flags := flag.NewFlagSet("validate", flag.ExitOnError)
+	asJson := flags.Bool("json", false, "print the report as JSON")
+	flags.Parse(args)
+
+	clipDir := os.Getenv("CLIP_DIRECTORY")
+	if clipDir == "" {
+		clipDir = "."
+	}
+
+	report := catalog.Validate(manifestPathFromEnv(), os.Getenv("CATEGORIES_FILE"), clipDir)
+
+	if *asJson {
+		bytes, err := json.MarshalIndent(&report, "", "\t")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to marshal report: %s\n", err)
+			return 2
+		}
+		fmt.Println(string(bytes))
+	} else {
+		for _, problem := range report.Problems {
+			fmt.Println(problem)
+		}
+		fmt.Printf("%d clips checked, %d problems\n", report.Clips, len(report.Problems))
+	}
+
+	if len(report.Problems) > 0 {
+		return 1
+	}
+	return 0
+}
@@ -45,8 +45,23 @@ export async function getManifest(difficulty) {
     return all;
 }
 
-function encryptedBody2Url(arrayBuf) {
-    const clipBytes = new Uint8Array(arrayBuf);
+// the backend can encrypt clips again on the way out so they're different
+// every time, the key comes in the Clip-Key header and the clip's real length
+// in the first 4 bytes under it, before the clip and some padding
+function unwrapClip(clipBytes, clipKey) {
+    if (!clipKey) {
+        return clipBytes;
+    }
+
+    const [key, iv] = clipKey.split(".");
+    const aesCtr = new aesjs.ModeOfOperation.ctr(aesjs.utils.hex.toBytes(key), aesjs.utils.hex.toBytes(iv));
+    const unwrapped = aesCtr.decrypt(clipBytes);
+    const length = new DataView(unwrapped.buffer, unwrapped.byteOffset, unwrapped.byteLength).getUint32(0);
+    return unwrapped.slice(4, 4 + length);
+}
+
+function encryptedBody2Url(arrayBuf, clipKey) {
+    const clipBytes = unwrapClip(new Uint8Array(arrayBuf), clipKey);
     const aesCtr = new aesjs.ModeOfOperation.ctr(MEDIA_KEY, MEDIA_IV);
     const decrypted = aesCtr.decrypt(clipBytes);
 
@@ -72,15 +87,57 @@ export async function getClipUrl(filename) {
 
 let token;
 
-export async function getFirstClip(difficulty) {
-    const params = new URLSearchParams();
-    params.set("difficulty", difficulty);
-    const response = await fetch(`${backendUrl}/clip?${params.toString()}`, {
+function leadingZeroBits(bytes) {
+    let zeros = 0;
+    for (const b of bytes) {
+        if (b != 0) {
+            return zeros + Math.clz32(b) - 24;
+        }
+        zeros += 8;
+    }
+    return zeros;
+}
+
+// the backend can make starting a run cost a proof of work, find a solution
+// where sha256(`${challenge}:${solution}`) starts with enough zero bits
+async function solveProofOfWork() {
+    const response = await fetch(`${backendUrl}/pow`);
+    const {challenge, difficulty} = await response.json();
+
+    const encoder = new TextEncoder();
+    for (let solution = 0; ; solution++) {
+        const hash = await crypto.subtle.digest("SHA-256", encoder.encode(`${challenge}:${solution}`));
+        if (leadingZeroBits(new Uint8Array(hash)) >= difficulty) {
+            return [challenge, solution.toString()];
+        }
+    }
+}
+
+async function startRun(params) {
+    const start = () => fetch(`${backendUrl}/clip?${params.toString()}`, {
         method: 'POST',
         headers: {
             "Auth-Token": ""
         }});
 
+    let response = await start();
+
+    // 428 again means it got harder while we were solving, try a new one
+    for (let tries = 0; response.status == 428 && tries < 3; tries++) {
+        const [challenge, solution] = await solveProofOfWork();
+        params.set("pow", challenge);
+        params.set("solution", solution);
+        response = await start();
+    }
+
+    return response;
+}
+
+export async function getFirstClip(difficulty) {
+    const params = new URLSearchParams();
+    params.set("difficulty", difficulty);
+    const response = await startRun(params);
+
     if (response.status != 200) {
         console.error(`got bad status ${response.status} from backend`);
         console.error(response);
@@ -96,7 +153,7 @@ export async function getFirstClip(difficulty) {
         return
     }
 
-    return encryptedBody2Url(arrayBuf);
+    return encryptedBody2Url(arrayBuf, response.headers.get('Clip-Key'));
 }
 
 export const INCORRECT_GUESS = "¯\\_(ツ)_/¯";
@@ -124,7 +181,7 @@ export async function getNextClip(guess) {
     // save header
     token = response.headers.get('Auth-Token');
     const arrayBuf = await response.arrayBuffer();
-    return [encryptedBody2Url(arrayBuf), null];
+    return [encryptedBody2Url(arrayBuf, response.headers.get('Clip-Key')), null];
 }
 
 export async function getHighscores() {
@@ -151,9 +208,17 @@ export async function submitHighscore(name) {
             }
         });
     
-        if (response.status != 201) {
+        // 202 means it's waiting to be checked before it goes on the board
+        if (response.status != 201 && response.status != 202) {
             console.error(`got bad status ${response.status} from backend`);
             console.error(response);
+
+            // names that don't pass come back with a reason, the message is
+            // fine to show as is
+            if (response.headers.get("Content-Type") == "application/json") {
+                const {error} = await response.json();
+                return error;
+            }
     
             return await response.text();
         }
@@ -394,7 +394,8 @@ function main() {
     })
 
     RESULTS_INPUT.addEventListener('input', () => {
-        const len = (new TextEncoder().encode(RESULTS_INPUT.value)).length;
+        // the backend counts characters once they're normalized
+        const len = [...RESULTS_INPUT.value.normalize("NFKC").trim()].length;
         if (len > 20) {
             RESULTS_SUBMIT_BTN.innerText = "Too long!";
             RESULTS_SUBMIT_BTN.disabled = true;